@@ -0,0 +1,318 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package api 对外的HTTP API服务，替代main.go中只支持GET /api?key=的handler
+//
+//	GET    /v1/groups/{group}/keys/{key}  获取value，Accept为application/json时返回JSON，否则返回原始内容
+//	PUT    /v1/groups/{group}/keys/{key}  写入value，请求体为原始内容，或者Content-Type为application/json的putRequest
+//	DELETE /v1/groups/{group}/keys/{key}  删除value
+//	POST   /v1/groups/{group}/keys        批量获取，请求体为mgetRequest，返回mgetResponse
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	YCache "seven-days-projects/YCache/YCache"
+	"strings"
+	"time"
+)
+
+const (
+	basePath = "/v1/groups/"
+	// defaultMaxBodyBytes 请求体的默认最大长度
+	defaultMaxBodyBytes = 32 << 20
+	// requestIDHeader 请求ID的header，客户端没有带上的时候由服务端生成
+	requestIDHeader = "X-Request-Id"
+	jsonType        = "application/json"
+)
+
+// Server 对外的HTTP API服务
+type Server struct {
+	// MaxBodyBytes PUT和批量获取请求体的最大长度，0表示使用默认值32MB
+	MaxBodyBytes int64
+	// Logger 访问日志，为nil时使用log包默认的Logger
+	Logger *log.Logger
+}
+
+// NewServer 创建HTTP API服务
+func NewServer() *Server {
+	return &Server{}
+}
+
+// valueResponse GET请求的JSON响应，Value在JSON中是base64编码
+type valueResponse struct {
+	Group   string     `json:"group"`
+	Key     string     `json:"key"`
+	Value   []byte     `json:"value"`
+	Version string     `json:"version,omitempty"`
+	Expire  *time.Time `json:"expire,omitempty"`
+}
+
+// putRequest JSON格式的PUT请求体，TTL为Go的duration格式，例如"10s"，为空表示不过期
+type putRequest struct {
+	Value []byte `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// mgetRequest 批量获取的请求体
+type mgetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// mgetResponse 批量获取的响应，获取失败的key放在Missing中
+type mgetResponse struct {
+	Values  map[string][]byte `json:"values"`
+	Missing []string          `json:"missing,omitempty"`
+}
+
+// errorResponse JSON格式的错误响应
+type errorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+}
+
+// ServeHTTP 记录访问日志，再分发到各个handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.route(rec, r, id)
+	s.logf("[YCache] %s %s %s %d %dB %v %s", r.RemoteAddr, r.Method, r.URL.RequestURI(), rec.status, rec.bytes, time.Since(start), id)
+}
+
+// route 解析路径中的group和key
+func (s *Server) route(w http.ResponseWriter, r *http.Request, id string) {
+	if !strings.HasPrefix(r.URL.Path, basePath) {
+		s.writeError(w, r, id, http.StatusNotFound, "not found")
+		return
+	}
+	// 使用EscapedPath，key中可以包含转义之后的/
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), basePath), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] != "keys" {
+		s.writeError(w, r, id, http.StatusNotFound, "not found")
+		return
+	}
+	groupName, err := url.PathUnescape(parts[0])
+	if err != nil {
+		s.writeError(w, r, id, http.StatusBadRequest, "bad group name")
+		return
+	}
+	group := YCache.GetGroup(groupName)
+	if group == nil {
+		s.writeError(w, r, id, http.StatusNotFound, "no such group: "+groupName)
+		return
+	}
+
+	// /v1/groups/{group}/keys
+	if len(parts) == 2 || parts[2] == "" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			s.writeError(w, r, id, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.handleMultiGet(w, r, id, group)
+		return
+	}
+
+	key, err := url.PathUnescape(parts[2])
+	if err != nil {
+		s.writeError(w, r, id, http.StatusBadRequest, "bad key")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.handleGet(w, r, id, group, key)
+	case http.MethodPut:
+		s.handlePut(w, r, id, group, key)
+	case http.MethodDelete:
+		s.handleDelete(w, r, id, group, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		s.writeError(w, r, id, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, id string, group *YCache.Group, key string) {
+	view, err := group.Get(key)
+	if err != nil {
+		s.writeError(w, r, id, statusOf(err), err.Error())
+		return
+	}
+	if v := view.Version(); v != "" {
+		w.Header().Set("ETag", `"`+v+`"`)
+	}
+	if !wantsJSON(r) {
+		contentType := view.ContentType()
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		view.WriteTo(w)
+		return
+	}
+	res := &valueResponse{Group: group.Name(), Key: key, Value: view.ByteSlice(), Version: view.Version()}
+	if e := view.Expire(); !e.IsZero() {
+		res.Expire = &e
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, id string, group *YCache.Group, key string) {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		s.writeError(w, r, id, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	value, ttlText := body, r.URL.Query().Get("ttl")
+	if isJSON(r.Header.Get("Content-Type")) {
+		var req putRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.writeError(w, r, id, http.StatusBadRequest, "bad request body: "+err.Error())
+			return
+		}
+		value, ttlText = req.Value, req.TTL
+	}
+	var ttl time.Duration
+	if ttlText != "" {
+		if ttl, err = time.ParseDuration(ttlText); err != nil || ttl < 0 {
+			s.writeError(w, r, id, http.StatusBadRequest, "bad ttl: "+ttlText)
+			return
+		}
+	}
+	if err := group.Set(key, value, ttl); errors.Is(err, YCache.ErrTooLarge) {
+		s.writeError(w, r, id, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		s.writeError(w, r, id, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, id string, group *YCache.Group, key string) {
+	if !group.Remove(key) {
+		s.writeError(w, r, id, http.StatusNotFound, "key not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleMultiGet(w http.ResponseWriter, r *http.Request, id string, group *YCache.Group) {
+	body, err := s.readBody(w, r)
+	if err != nil {
+		s.writeError(w, r, id, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	var req mgetRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.writeError(w, r, id, http.StatusBadRequest, "bad request body: "+err.Error())
+		return
+	}
+	res := &mgetResponse{Values: make(map[string][]byte, len(req.Keys))}
+	for _, key := range req.Keys {
+		view, err := group.Get(key)
+		if err != nil {
+			res.Missing = append(res.Missing, key)
+			continue
+		}
+		res.Values[key] = view.ByteSlice()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// readBody 读取请求体，超过MaxBodyBytes的时候返回错误
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := s.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+}
+
+// writeError 按照客户端接受的格式返回错误
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, id string, status int, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, &errorResponse{Error: msg, RequestID: id})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// statusOf 数据源中不存在的key返回404，其他错误返回500
+func statusOf(err error) int {
+	if errors.Is(err, YCache.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// wantsJSON 客户端是否接受JSON格式的响应
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if isJSON(accept) {
+			return true
+		}
+	}
+	return false
+}
+
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(strings.TrimSpace(contentType))
+	return err == nil && t == jsonType
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", jsonType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newRequestID 生成随机的请求ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder 记录响应的状态码和长度，用于访问日志
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
@@ -0,0 +1,122 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	YCache "seven-days-projects/YCache/YCache"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	db := map[string]string{"Tom": "630", "Jack": "589"}
+	YCache.NewGroup("api-scores", 2<<10, YCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}))
+	var logs bytes.Buffer
+	srv := httptest.NewServer(&Server{Logger: log.New(&logs, "", 0)})
+	defer srv.Close()
+
+	do := func(method, path, accept, contentType, body string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	read := func(res *http.Response) string {
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	res := do("GET", "/v1/groups/api-scores/keys/Tom", "", "", "")
+	if body := read(res); res.StatusCode != http.StatusOK || body != "630" || res.Header.Get(requestIDHeader) == "" {
+		t.Fatalf("raw get: %d %q", res.StatusCode, body)
+	}
+
+	// Loader设置的MIME类型作为Content-Type返回
+	YCache.NewGroup("api-typed", 2<<10, YCache.LoaderFunc(func(key string) ([]byte, YCache.LoadOptions, error) {
+		return []byte(`{"score":630}`), YCache.LoadOptions{ContentType: "application/json"}, nil
+	}))
+	res = do("GET", "/v1/groups/api-typed/keys/Tom", "", "", "")
+	if body := read(res); res.Header.Get("Content-Type") != "application/json" || body != `{"score":630}` {
+		t.Fatalf("typed get: %q %q", res.Header.Get("Content-Type"), body)
+	}
+
+	res = do("GET", "/v1/groups/api-scores/keys/Jack", "application/json", "", "")
+	var value valueResponse
+	if err := json.NewDecoder(res.Body).Decode(&value); err != nil || string(value.Value) != "589" || value.Key != "Jack" {
+		t.Fatalf("json get: %v %+v", err, value)
+	}
+	res.Body.Close()
+
+	for _, path := range []string{"/v1/groups/api-scores/keys/Sam", "/v1/groups/unknown/keys/Tom", "/api?key=Tom"} {
+		if res = do("GET", path, "", "", ""); res.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, res.StatusCode)
+		}
+		read(res)
+	}
+
+	// JSON格式的PUT请求，key中带有转义的/
+	res = do("PUT", "/v1/groups/api-scores/keys/a%2Fb", "", "application/json", `{"value":"NTY3","ttl":"1m"}`)
+	if read(res); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("json put: got %d", res.StatusCode)
+	}
+	res = do("PUT", "/v1/groups/api-scores/keys/Kate?ttl=bad", "", "", "1")
+	if read(res); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad ttl: got %d", res.StatusCode)
+	}
+	res = do("PUT", "/v1/groups/api-scores/keys/Kate", "", "", "601")
+	if read(res); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("raw put: got %d", res.StatusCode)
+	}
+
+	res = do("POST", "/v1/groups/api-scores/keys", "", "application/json", `{"keys":["a/b","Kate","Sam"]}`)
+	var mget mgetResponse
+	if err := json.NewDecoder(res.Body).Decode(&mget); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if string(mget.Values["a/b"]) != "567" || string(mget.Values["Kate"]) != "601" || len(mget.Missing) != 1 || mget.Missing[0] != "Sam" {
+		t.Fatalf("multi-get: %+v", mget)
+	}
+
+	res = do("DELETE", "/v1/groups/api-scores/keys/Kate", "", "", "")
+	if read(res); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: got %d", res.StatusCode)
+	}
+	res = do("DELETE", "/v1/groups/api-scores/keys/Kate", "application/json", "", "")
+	if body := read(res); res.StatusCode != http.StatusNotFound || !strings.Contains(body, `"request_id"`) {
+		t.Fatalf("delete missing key: %d %q", res.StatusCode, body)
+	}
+
+	res = do("PATCH", "/v1/groups/api-scores/keys/Tom", "", "", "")
+	if read(res); res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("patch: got %d", res.StatusCode)
+	}
+	if !strings.Contains(logs.String(), "GET /v1/groups/api-scores/keys/Tom 200") {
+		t.Fatalf("access log missing: %s", logs.String())
+	}
+}
@@ -0,0 +1,121 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	timestampHeader = "X-YCache-Timestamp" // 请求签名的时间戳
+	signatureHeader = "X-YCache-Signature" // 请求签名
+
+	// maxClockSkew 签名时间戳和服务端时间最多相差多少，超过的请求被拒绝，避免请求被截获之后重放
+	maxClockSkew = 5 * time.Minute
+)
+
+// loadCertPool 加载CA证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	return pool, nil
+}
+
+// clientTLSConfig 请求其他节点时使用的TLS配置，CertFile作为客户端证书，CAFile用于校验服务端证书
+func (p *HTTPPool) clientTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if p.opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.opts.CertFile, p.opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if p.opts.CAFile != "" {
+		pool, err := loadCertPool(p.opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// ServerTLSConfig 节点对外服务时使用的TLS配置，设置了CAFile的时候要求并校验客户端证书
+// 使用方式：server := &http.Server{Addr: addr, Handler: pool, TLSConfig: config}; server.ListenAndServeTLS("", "")
+func (p *HTTPPool) ServerTLSConfig() (*tls.Config, error) {
+	if p.opts.CertFile == "" {
+		return nil, fmt.Errorf("CertFile is required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(p.opts.CertFile, p.opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %v", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if p.opts.CAFile != "" {
+		pool, err := loadCertPool(p.opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// sign 计算请求的HMAC-SHA256签名，签名内容是请求方法、路径和时间戳
+func sign(secret, method, uri, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest 给请求加上时间戳和签名
+func signRequest(req *http.Request, secret string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, sign(secret, req.Method, req.URL.RequestURI(), timestamp))
+}
+
+// verifyRequest 校验请求的签名和时间戳
+func verifyRequest(r *http.Request, secret string) error {
+	timestamp := r.Header.Get(timestampHeader)
+	signature := r.Header.Get(signatureHeader)
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("missing signature")
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp: %v", err)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("timestamp out of range")
+	}
+	expect := sign(secret, r.Method, r.URL.RequestURI(), timestamp)
+	if !hmac.Equal([]byte(expect), []byte(signature)) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"testing"
+	"time"
+)
+
+// writeCert 生成证书和私钥并写入dir，parent为nil的时候生成自签名的CA证书
+func writeCert(t *testing.T, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// startPeer 启动一个使用pool处理请求的节点，tls为true的时候使用pool的服务端TLS配置
+func startPeer(t *testing.T, pool *HTTPPool, tls bool) *httptest.Server {
+	srv := httptest.NewUnstartedServer(pool)
+	if !tls {
+		srv.Start()
+		return srv
+	}
+	config, err := pool.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.TLS = config
+	srv.StartTLS()
+	return srv
+}
+
+// fetch 通过client节点请求peer节点上的key
+func fetch(client *HTTPPool, peer, key string) (string, error) {
+	client.Set(client.self, peer)
+	res := &ycachepb.Response{}
+	err := client.httpGetters[peer].Get(&ycachepb.Request{Group: "auth", Key: key}, res)
+	return string(res.Value), err
+}
+
+// TestMutualTLS 双向TLS，没有客户端证书的请求被拒绝
+func TestMutualTLS(t *testing.T) {
+	NewGroup("auth", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}))
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", 1, nil, nil)
+	writeCert(t, dir, "node1", 2, ca, caKey)
+	writeCert(t, dir, "node2", 3, ca, caKey)
+	opts := func(node string) *HTTPPoolOptions {
+		return &HTTPPoolOptions{
+			CertFile: filepath.Join(dir, node+".crt"),
+			KeyFile:  filepath.Join(dir, node+".key"),
+			CAFile:   filepath.Join(dir, "ca.crt"),
+		}
+	}
+
+	server, err := NewHTTPPoolOpts("https://node1", opts("node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := startPeer(t, server, true)
+	defer srv.Close()
+
+	client, err := NewHTTPPoolOpts("https://node2", opts("node2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := fetch(client, srv.URL, "Tom"); err != nil || v != "v-Tom" {
+		t.Fatalf("mutual TLS request failed: %v", err)
+	}
+
+	// 只信任CA，但是没有客户端证书
+	anonymous, err := NewHTTPPoolOpts("https://node3", &HTTPPoolOptions{CAFile: filepath.Join(dir, "ca.crt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetch(anonymous, srv.URL, "Tom"); err == nil {
+		t.Fatalf("request without client certificate should be rejected")
+	}
+
+	if _, err := NewHTTPPoolOpts("https://node4", &HTTPPoolOptions{CAFile: filepath.Join(dir, "missing.crt")}); err == nil {
+		t.Fatalf("missing CA file should be reported")
+	}
+}
+
+// TestHMACSignature 共享密钥签名，密钥不一致或者没有签名的请求被拒绝
+func TestHMACSignature(t *testing.T) {
+	NewGroup("auth", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}))
+	server, _ := NewHTTPPoolOpts("http://node1", &HTTPPoolOptions{Secret: "secret"})
+	srv := startPeer(t, server, false)
+	defer srv.Close()
+
+	client, _ := NewHTTPPoolOpts("http://node2", &HTTPPoolOptions{Secret: "secret"})
+	if v, err := fetch(client, srv.URL, "Tom"); err != nil || v != "v-Tom" {
+		t.Fatalf("signed request failed: %v", err)
+	}
+	for _, secret := range []string{"wrong", ""} {
+		other, _ := NewHTTPPoolOpts("http://node3", &HTTPPoolOptions{Secret: secret})
+		if _, err := fetch(other, srv.URL, "Tom"); err == nil {
+			t.Fatalf("request signed with %q should be rejected", secret)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// InvalidationEvent 失效事件，通知订阅者group中的key或者带有tag的记录已经变化
+type InvalidationEvent struct {
+	Source string // 发布事件的节点
+	Epoch  int64  // Source启动的时间，Source重启之后Seq重新从1开始
+	Seq    uint64 // 同一个Source的同一个Epoch内从1开始连续递增
+	Group  string // 为空表示所有group，只对Tag有效
+	Key    string // Key和Tag二选一
+	Tag    string
+
+	// Reset 表示Source的一部分事件丢失并且无法补齐，订阅者应该认为Source修改过的所有记录都可能已经变化
+	// Reset事件由接收方生成，只有Source和Epoch
+	Reset bool
+}
+
+// InvalidationBus 失效事件总线，每个Source发布的事件按照Seq的顺序交给订阅者，同一个事件只交一次
+// 订阅者会收到所有事件，包括当前节点自己发布的事件，可以通过Source和Node判断
+type InvalidationBus interface {
+	// Node 当前节点的名称，也就是Publish发布的事件的Source
+	Node() string
+	// Publish 发布事件，Source、Epoch和Seq由总线填写
+	Publish(e InvalidationEvent) error
+	// Subscribe 注册订阅者，订阅者按顺序同步调用，不能在订阅者中同步调用Publish
+	Subscribe(fn func(e InvalidationEvent))
+}
+
+// subscribers 订阅者列表，InvalidationBus的实现共用
+type subscribers struct {
+	mu  sync.Mutex
+	fns []func(e InvalidationEvent)
+}
+
+// add 注册订阅者
+func (s *subscribers) add(fn func(e InvalidationEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fns = append(s.fns, fn)
+}
+
+// deliver 把事件交给所有订阅者，调用方负责保证顺序
+func (s *subscribers) deliver(e InvalidationEvent) {
+	s.mu.Lock()
+	fns := s.fns
+	s.mu.Unlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+}
+
+// LocalBus 进程内的InvalidationBus，Publish直接按顺序交给订阅者，适合单进程部署，
+// 或者通知进程内cache之外的副本，例如应用自己保存的解码之后的对象
+type LocalBus struct {
+	node  string
+	epoch int64
+	subs  subscribers
+
+	mu  sync.Mutex // 保护seq，同时保证事件按顺序交给订阅者
+	seq uint64
+}
+
+// NewLocalBus LocalBus构造函数，node是事件的Source
+func NewLocalBus(node string) *LocalBus {
+	return &LocalBus{node: node, epoch: time.Now().UnixNano()}
+}
+
+// Node 实现InvalidationBus接口
+func (b *LocalBus) Node() string {
+	return b.node
+}
+
+// Publish 实现InvalidationBus接口，返回之前所有订阅者都已经处理了这个事件
+func (b *LocalBus) Publish(e InvalidationEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.Source, e.Epoch, e.Seq, e.Reset = b.node, b.epoch, b.seq, false
+	b.subs.deliver(e)
+	return nil
+}
+
+// Subscribe 实现InvalidationBus接口
+func (b *LocalBus) Subscribe(fn func(e InvalidationEvent)) {
+	b.subs.add(fn)
+}
+
+// SetInvalidationBus 把Group接入失效事件总线
+// Set、Remove和InvalidateTag会发布事件，其他节点发布的事件会删除当前节点对应的记录，
+// Reset事件会清空hotCache，并且删除mainCache中不属于当前节点的key，见OwnerPicker
+// 属于当前节点的key只会被当前节点的Set修改，或者从数据源加载，不受其他节点丢失的事件影响
+func (g *Group) SetInvalidationBus(bus InvalidationBus) {
+	if g.bus != nil {
+		panic("SetInvalidationBus called more than once")
+	}
+	g.bus = bus
+	bus.Subscribe(g.onInvalidation)
+}
+
+// onInvalidation 处理其他节点发布的失效事件，当前节点发布的事件在发布之前已经处理过
+func (g *Group) onInvalidation(e InvalidationEvent) {
+	if e.Source == g.bus.Node() {
+		return
+	}
+	if e.Reset {
+		g.hotCache.clear()
+		n := g.removeUnowned()
+		log.Printf("[YCache] %s: lost invalidations from %s, hot cache cleared, %d entries owned by other nodes removed", g.name, e.Source, n)
+		return
+	}
+	if e.Group != "" && e.Group != g.name {
+		return
+	}
+	if e.Tag != "" {
+		g.invalidateTag(e.Tag)
+	} else if e.Key != "" {
+		g.removeLocally(e.Key)
+	}
+}
+
+// removeUnowned 删除mainCache中不属于当前节点的key，PeerPicker没有实现OwnerPicker的时候不删除
+func (g *Group) removeUnowned() int {
+	owner, ok := g.peers.(OwnerPicker)
+	if !ok {
+		return 0
+	}
+	return g.mainCache.removeIf(func(key string) bool {
+		return !owner.IsOwner(key)
+	})
+}
+
+// publish 发布失效事件，没有设置总线的时候什么都不做
+func (g *Group) publish(e InvalidationEvent) error {
+	if g.bus == nil {
+		return nil
+	}
+	return g.bus.Publish(e)
+}
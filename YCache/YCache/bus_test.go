@@ -0,0 +1,265 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder 记录订阅者收到的事件
+type recorder struct {
+	mu     sync.Mutex
+	events []InvalidationEvent
+}
+
+func (r *recorder) record(e InvalidationEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// seqs 收到的事件的seq，Reset事件记为0
+func (r *recorder) seqs() []uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var seqs []uint64
+	for _, e := range r.events {
+		seqs = append(seqs, e.Seq)
+	}
+	return seqs
+}
+
+// TestLocalBus Group发布的事件按顺序交给订阅者，Group自己不会处理当前节点发布的事件
+func TestLocalBus(t *testing.T) {
+	bus := NewLocalBus("self")
+	var rec recorder
+	bus.Subscribe(rec.record)
+	g := NewGroup("local-bus", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.SetInvalidationBus(bus)
+
+	g.Set("Tom", []byte("630"), 0)
+	g.Remove("Jack")
+	g.InvalidateTag("user:1")
+	want := []InvalidationEvent{
+		{Group: "local-bus", Key: "Tom"},
+		{Group: "local-bus", Key: "Jack"},
+		{Group: "local-bus", Tag: "user:1"},
+	}
+	for i := range want {
+		want[i].Source, want[i].Epoch, want[i].Seq = "self", bus.epoch, uint64(i+1)
+	}
+	if !reflect.DeepEqual(rec.events, want) {
+		t.Fatalf("events = %+v, want %+v", rec.events, want)
+	}
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("own event should not remove the value just set: %v %v", v, err)
+	}
+}
+
+// newBusPair 创建两个互相知道对方的节点，dropB为true的时候B拒绝所有请求，模拟事件丢失
+func newBusPair(t *testing.T, opts *PeerBusOptions) (busA, busB *PeerBus, dropB *int32) {
+	var poolA, poolB *HTTPPool
+	dropB = new(int32)
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { poolA.ServeHTTP(w, r) }))
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(dropB) == 1 {
+			http.Error(w, "dropped", http.StatusServiceUnavailable)
+			return
+		}
+		poolB.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srvA.Close)
+	t.Cleanup(srvB.Close)
+	poolA, _ = NewHTTPPoolOpts(srvA.URL, &HTTPPoolOptions{Secret: "s3cret"})
+	poolB, _ = NewHTTPPoolOpts(srvB.URL, &HTTPPoolOptions{Secret: "s3cret"})
+	poolA.Set(srvA.URL, srvB.URL)
+	poolB.Set(srvA.URL, srvB.URL)
+	return NewPeerBus(poolA, opts), NewPeerBus(poolB, opts), dropB
+}
+
+// TestPeerBus 事件按顺序送达，丢失的事件在下一个事件暴露缺口的时候补齐，重复的事件被丢弃
+func TestPeerBus(t *testing.T) {
+	busA, busB, dropB := newBusPair(t, nil)
+	var rec recorder
+	busB.Subscribe(rec.record)
+
+	for i := 0; i < 2; i++ {
+		if err := busA.Publish(InvalidationEvent{Group: "g", Key: "k"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atomic.StoreInt32(dropB, 1)
+	if err := busA.Publish(InvalidationEvent{Group: "g", Key: "lost"}); err == nil {
+		t.Fatalf("publish to an unavailable peer should fail")
+	}
+	atomic.StoreInt32(dropB, 0)
+	busA.Publish(InvalidationEvent{Group: "g", Tag: "t"})
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{1, 2, 3, 4}) {
+		t.Fatalf("seqs = %v", got)
+	}
+	if rec.events[2].Key != "lost" || busB.Stats.Gaps.Get() != 1 || busB.Stats.Resets.Get() != 0 {
+		t.Fatalf("missed event should be recovered from the log: %+v", rec.events[2])
+	}
+
+	// 重复的事件被丢弃
+	busB.receive(rec.events[1])
+	if busB.Stats.Duplicates.Get() != 1 || len(rec.seqs()) != 4 {
+		t.Fatalf("duplicate event should be dropped")
+	}
+
+	// 最后一个事件丢失，只能通过定期拉取发现
+	atomic.StoreInt32(dropB, 1)
+	busA.Publish(InvalidationEvent{Group: "g", Key: "tail"})
+	atomic.StoreInt32(dropB, 0)
+	if err := busB.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.seqs(); len(got) != 5 || rec.events[4].Key != "tail" {
+		t.Fatalf("Sync should fetch the tail event, got %v", got)
+	}
+}
+
+// TestPeerBusReset 缺失的事件已经从日志中淘汰的时候，订阅者收到Reset事件，
+// Group清空hotCache，并删除mainCache中属于其他节点的key
+func TestPeerBusReset(t *testing.T) {
+	busA, busB, dropB := newBusPair(t, &PeerBusOptions{LogSize: 1})
+	var rec recorder
+	busB.Subscribe(rec.record)
+	g := NewGroup("peer-bus", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.SetInvalidationBus(busB)
+	g.SetHotCache(2<<10, 0)
+	g.RegisterPeers(busB.pool)
+	g.Set("Tom", []byte("630"), 0)
+	g.hotCache.Add("Jack", &ByteView{b: []byte("589")})
+	// 分别找一个属于B和属于A的key，都写入B的mainCache
+	var owned, unowned string
+	for i := 0; owned == "" || unowned == ""; i++ {
+		if key := fmt.Sprint("key-", i); busB.pool.IsOwner(key) {
+			owned = key
+		} else {
+			unowned = key
+		}
+	}
+	g.mainCache.Add(owned, &ByteView{b: []byte("mine")})
+	g.mainCache.Add(unowned, &ByteView{b: []byte("stale")})
+
+	busA.Publish(InvalidationEvent{Group: "peer-bus", Key: "Tom"})
+	if _, ok := g.mainCache.peek("Tom"); ok {
+		t.Fatalf("event from another node should remove the key")
+	}
+
+	atomic.StoreInt32(dropB, 1)
+	busA.Publish(InvalidationEvent{Group: "peer-bus", Key: "a"})
+	busA.Publish(InvalidationEvent{Group: "peer-bus", Key: "b"})
+	atomic.StoreInt32(dropB, 0)
+	busA.Publish(InvalidationEvent{Group: "peer-bus", Key: "c"})
+
+	// 自己发布的Set事件、A的第1个事件、Reset、A的第4个事件
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{1, 1, 0, 4}) || !rec.events[2].Reset {
+		t.Fatalf("seqs = %v", got)
+	}
+	if _, ok := g.hotCache.peek("Jack"); ok || busB.Stats.Resets.Get() != 1 {
+		t.Fatalf("reset should clear the hot cache")
+	}
+	if _, ok := g.mainCache.peek(unowned); ok {
+		t.Fatalf("reset should remove keys owned by other nodes")
+	}
+	if _, ok := g.mainCache.peek(owned); !ok {
+		t.Fatalf("reset should keep keys owned by this node")
+	}
+}
+
+// TestPeerBusFirstSync 第一次同步的节点日志中最早的事件已经被淘汰的时候不会Reset，记录过位置之后再丢失事件才Reset
+func TestPeerBusFirstSync(t *testing.T) {
+	busA, busB, dropB := newBusPair(t, &PeerBusOptions{LogSize: 1})
+	var rec recorder
+	busB.Subscribe(rec.record)
+
+	atomic.StoreInt32(dropB, 1)
+	busA.Publish(InvalidationEvent{Group: "g", Key: "a"})
+	busA.Publish(InvalidationEvent{Group: "g", Key: "b"})
+	atomic.StoreInt32(dropB, 0)
+	if err := busB.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{2}) || busB.Stats.Resets.Get() != 0 {
+		t.Fatalf("first sync should start from the log without reset, seqs %v, resets %d", got, busB.Stats.Resets.Get())
+	}
+
+	// 第一次收到的事件之前有缺口，同样不会Reset
+	busB.receive(InvalidationEvent{Source: "fresh", Epoch: 1, Seq: 5, Group: "g", Key: "k"})
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{2, 5}) || busB.Stats.Resets.Get() != 0 {
+		t.Fatalf("first event from a peer should not reset, seqs %v, resets %d", got, busB.Stats.Resets.Get())
+	}
+
+	atomic.StoreInt32(dropB, 1)
+	busA.Publish(InvalidationEvent{Group: "g", Key: "c"})
+	busA.Publish(InvalidationEvent{Group: "g", Key: "d"})
+	atomic.StoreInt32(dropB, 0)
+	if err := busB.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{2, 5, 0, 4}) || busB.Stats.Resets.Get() != 1 {
+		t.Fatalf("lost events after the first sync should reset, seqs %v, resets %d", got, busB.Stats.Resets.Get())
+	}
+}
+
+// TestPeerBusStaleEpoch 发布方重启之前的事件晚到的时候直接丢弃，不会把cursor退回旧的epoch
+func TestPeerBusStaleEpoch(t *testing.T) {
+	_, busB, _ := newBusPair(t, nil)
+	var rec recorder
+	busB.Subscribe(rec.record)
+
+	busB.receive(InvalidationEvent{Source: "restarted", Epoch: 2, Seq: 1, Group: "g", Key: "new"})
+	busB.receive(InvalidationEvent{Source: "restarted", Epoch: 1, Seq: 5, Group: "g", Key: "old"})
+	busB.receive(InvalidationEvent{Source: "restarted", Epoch: 2, Seq: 2, Group: "g", Key: "next"})
+	if got := rec.seqs(); !reflect.DeepEqual(got, []uint64{1, 2}) || rec.events[1].Key != "next" {
+		t.Fatalf("seqs = %v", got)
+	}
+	if busB.Stats.Stale.Get() != 1 || busB.Stats.Resets.Get() != 0 {
+		t.Fatalf("stale event should be dropped without reset, stale %d, resets %d", busB.Stats.Stale.Get(), busB.Stats.Resets.Get())
+	}
+}
+
+// TestPeerBusFetchUnlocked 向发布方拉取缺失的事件的时候，其他事件和当前节点的Publish不会被阻塞
+func TestPeerBusFetchUnlocked(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer slow.Close()
+	defer close(release)
+	_, busB, _ := newBusPair(t, nil)
+
+	go busB.receive(InvalidationEvent{Source: slow.URL, Epoch: 1, Seq: 3, Group: "g", Key: "k"})
+	for busB.Stats.Gaps.Get() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	published := make(chan struct{})
+	go func() {
+		busB.Publish(InvalidationEvent{Group: "g", Key: "local"})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("Publish should not wait for a fetch from another node")
+	}
+}
@@ -6,17 +6,134 @@
 
 package YCache
 
+import (
+	"bytes"
+	"io"
+	"log"
+	"time"
+	"unsafe"
+)
+
+// byteViewOverhead 每个*ByteView在value长度之外额外占用的内存，即ByteView结构体本身的大小，字段变化时自动跟随
+const byteViewOverhead = int64(unsafe.Sizeof(ByteView{}))
+
 // ByteView 对记录的value，封装自定义数据类型
 type ByteView struct {
 	b []byte
+	e time.Time    // 过期时间，零值表示不过期
+	z bool         // b是否是gzip压缩后的数据，读取的时候透明解压
+	v string       // value的版本，用于条件请求
+	o *LoadOptions // Loader返回的缓存选项，为nil表示没有设置，使用指针避免每条记录都多占用选项的内存
+}
+
+// Version 获取value的版本，默认是内容的hash
+func (v *ByteView) Version() string {
+	return v.v
+}
+
+// Expire 获取过期时间，零值表示不过期
+func (v *ByteView) Expire() time.Time {
+	return v.e
+}
+
+// Tags 获取Loader为value设置的标签，调用方不能修改
+func (v *ByteView) Tags() []string {
+	if v.o == nil {
+		return nil
+	}
+	return v.o.Tags
+}
+
+// ContentType 获取Loader为value设置的MIME类型，没有设置的时候返回空字符串
+func (v *ByteView) ContentType() string {
+	if v.o == nil {
+		return ""
+	}
+	return v.o.ContentType
+}
+
+// Flags 获取为value设置的标志，没有设置的时候返回0
+func (v *ByteView) Flags() uint32 {
+	if v.o == nil {
+		return 0
+	}
+	return v.o.Flags
+}
+
+// Priority 实现lru.Prioritizer接口，返回淘汰优先级
+func (v *ByteView) Priority() int {
+	if v.o == nil {
+		return 0
+	}
+	return v.o.Priority
 }
 
+// Cost 实现lru.Coster接口，返回计入cache的内存，没有设置的时候是value的长度
+func (v *ByteView) Cost() int64 {
+	if v.o != nil && v.o.Cost > 0 {
+		return v.o.Cost
+	}
+	return int64(len(v.b))
+}
+
+// noCache 判断Loader是否要求不缓存这个value
+func (v *ByteView) noCache() bool {
+	return v.o != nil && v.o.NoCache
+}
+
+// expired 判断记录在now时刻是否已经过期
+func (v *ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
+}
+
+// Len 返回value占用的内存，压缩存储的value返回压缩后的长度
 func (v *ByteView) Len() int {
 	return len(v.b)
 }
 
 func (v *ByteView) String() string {
-	return string(v.b)
+	return string(v.data())
+}
+
+// data 返回未压缩的数据，没有压缩的时候直接返回b，不会拷贝，调用方不能修改
+// 压缩存储的value只在cache内部出现，交给调用方之前已经由decompressed解压，解压失败的记录不会交给调用方
+func (v *ByteView) data() []byte {
+	if !v.z {
+		return v.b
+	}
+	b, err := decompress(getCompressor("gzip"), v.b, 0)
+	if err != nil {
+		log.Println("[YCache] decompress value failed:", err)
+	}
+	return b
+}
+
+// decompressed 返回未压缩的ByteView，没有压缩的时候返回自己，解压失败说明数据已经损坏，返回错误
+func (v *ByteView) decompressed() (*ByteView, error) {
+	if !v.z {
+		return v, nil
+	}
+	b, err := decompress(getCompressor("gzip"), v.b, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := *v
+	d.b, d.z = b, false
+	return &d, nil
+}
+
+// compressed 返回gzip压缩之后的ByteView，压缩之后没有变小的时候返回自己
+func (v *ByteView) compressed() *ByteView {
+	if v.z {
+		return v
+	}
+	b, err := compress(getCompressor("gzip"), v.b)
+	if err != nil || len(b) >= len(v.b) {
+		return v
+	}
+	c := *v
+	c.b, c.z = b, true
+	return &c
 }
 
 // 拷贝一份ByteView的b数组
@@ -27,6 +144,57 @@ func cloneBytes(b []byte) []byte {
 }
 
 // ByteSlice 的b是只读的，防止缓存值被外部程序修改
+// 每次调用都会拷贝一份，只需要读取的时候使用Reader、WriteTo、At、Slice、Copy、Equal，不会拷贝
 func (v ByteView) ByteSlice() []byte {
+	// 解压的时候已经生成了新的数组，不需要再拷贝
+	if v.z {
+		return v.data()
+	}
 	return cloneBytes(v.b)
-}
\ No newline at end of file
+}
+
+// Reader 返回读取value的io.ReadSeeker，直接读取cache中的数据，不会拷贝
+func (v *ByteView) Reader() io.ReadSeeker {
+	return bytes.NewReader(v.data())
+}
+
+// WriteTo 实现io.WriterTo接口，把value直接写入w，不会拷贝
+func (v *ByteView) WriteTo(w io.Writer) (int64, error) {
+	b := v.data()
+	n, err := w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// At 返回下标i处的字节
+func (v *ByteView) At(i int) byte {
+	return v.data()[i]
+}
+
+// Slice 返回[from, to)之间的数据，和v共享底层数组，不会拷贝
+// 返回的ByteView沿用v的过期时间，但不再有版本
+func (v *ByteView) Slice(from, to int) *ByteView {
+	return &ByteView{b: v.data()[from:to], e: v.e}
+}
+
+// Copy 把value拷贝到dest中，返回拷贝的字节数，调用方可以复用dest避免每次分配内存
+func (v *ByteView) Copy(dest []byte) int {
+	return copy(dest, v.data())
+}
+
+// Equal 判断两个ByteView的内容是否相同，不比较过期时间和版本
+func (v *ByteView) Equal(b2 *ByteView) bool {
+	return bytes.Equal(v.data(), b2.data())
+}
+
+// EqualBytes 判断value是否和b相同
+func (v *ByteView) EqualBytes(b []byte) bool {
+	return bytes.Equal(v.data(), b)
+}
+
+// EqualString 判断value是否和s相同
+func (v *ByteView) EqualString(s string) bool {
+	return string(v.data()) == s
+}
@@ -7,8 +7,11 @@
 package YCache
 
 import (
+	"log"
+	"seven-days-projects/YCache/YCache/diskcache"
 	"seven-days-projects/YCache/YCache/lru"
 	"sync"
+	"time"
 )
 
 // cacheInstance cache实例，在lru算法的基础上封装了mutex互斥锁，解决办法问题
@@ -16,29 +19,269 @@ type cacheInstance struct {
 	mu         sync.Mutex
 	cache      *lru.Cache
 	cacheBytes int64  // cacheInstance最大占用内存
+
+	limiter  *MemoryLimiter // 进程级内存预算，为nil表示只受cacheBytes限制
+	overhead int64          // 每条记录额外计入的内存，为0表示只统计key和value的长度
+
+	disk     *diskcache.Store // 二级磁盘缓存，从内存中淘汰的记录写入磁盘，为nil表示不使用
+	removing bool             // 正在主动删除记录，被删除的记录不写入磁盘
 }
 
 // Add 封装并发控制
 func (c *cacheInstance) Add(key string, value *ByteView) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.cache == nil { // Lazy Initialization 延时初始化
-		c.cache = lru.NewCache(c.cacheBytes, nil)
+		c.cache = lru.NewCache(c.cacheBytes, c.onEvicted)
+		c.cache.SetEntryOverhead(c.overhead)
 	}
 	// 添加记录, value必须实现Value接口的所有方法
 	c.cache.Add(key, value)
+	// 内存中已经是最新的value，磁盘上的旧value作废
+	if c.disk != nil {
+		if err := c.disk.Remove(key); err != nil {
+			log.Println("[YCache] disk remove failed:", err)
+		}
+	}
+	limiter := c.limiter
+	c.mu.Unlock()
+
+	// 释放自身的锁之后再检查全局预算，避免与MemoryLimiter的锁顺序相反
+	if limiter != nil {
+		limiter.enforce()
+	}
 }
 
 func (c *cacheInstance) GetValue(key string) (value *ByteView, ok bool) {
+	if value, ok = c.getMemory(key); ok {
+		return
+	}
+	// 内存中没有，再从磁盘中获取，命中之后重新放回内存
+	if value, ok = c.getDisk(key); ok {
+		c.Add(key, value)
+	}
+	return
+}
+
+// getMemory 从内存中获取记录
+func (c *cacheInstance) getMemory(key string) (value *ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.cache == nil {
 		return
 	}
-	// 获取记录
+	// 获取记录，过期的记录直接删除
 	if v, ok := c.cache.GetValue(key); ok {
+		if v.(*ByteView).expired(time.Now()) {
+			c.cache.Remove(key)
+			return nil, false
+		}
 		return v.(*ByteView), ok
 	}
 
 	return
 }
+
+// peek 从内存中获取记录，过期的记录也会返回，并且不会删除
+func (c *cacheInstance) peek(key string) (value *ByteView, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return
+	}
+	if v, ok := c.cache.GetValue(key); ok {
+		return v.(*ByteView), true
+	}
+	return
+}
+
+// getDisk 从磁盘中获取记录
+func (c *cacheInstance) getDisk(key string) (value *ByteView, ok bool) {
+	c.mu.Lock()
+	disk := c.disk
+	c.mu.Unlock()
+	if disk == nil {
+		return
+	}
+	b, expire, ok, err := disk.Get(key)
+	if err != nil {
+		log.Println("[YCache] disk get failed:", err)
+		return nil, false
+	}
+	if !ok {
+		return
+	}
+	value = &ByteView{b: b}
+	if expire != 0 {
+		value.e = time.Unix(0, expire)
+	}
+	if value.expired(time.Now()) {
+		disk.Remove(key)
+		return nil, false
+	}
+	return value, true
+}
+
+// onEvicted lru淘汰记录的回调函数，没有过期的记录写入磁盘
+// 磁盘中不保存标签，带标签的记录写入磁盘之后就无法按标签失效，因此直接丢弃
+func (c *cacheInstance) onEvicted(key string, v lru.Value) {
+	value := v.(*ByteView)
+	if c.disk == nil || c.removing || value.expired(time.Now()) || len(value.Tags()) > 0 {
+		return
+	}
+	var expire int64
+	if !value.e.IsZero() {
+		expire = value.e.UnixNano()
+	}
+	data, err := value.decompressed()
+	if err != nil {
+		log.Println("[YCache] decompress evicted value failed:", err)
+		return
+	}
+	if err := c.disk.Put(key, data.b, expire); err != nil {
+		log.Println("[YCache] disk put failed:", err)
+	}
+}
+
+// remove 从内存和磁盘中删除记录，返回内存中是否存在这条记录
+func (c *cacheInstance) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ok bool
+	if c.cache != nil {
+		if _, ok = c.cache.GetValue(key); ok {
+			c.removing = true
+			c.cache.Remove(key)
+			c.removing = false
+		}
+	}
+	if c.disk != nil {
+		if err := c.disk.Remove(key); err != nil {
+			log.Println("[YCache] disk remove failed:", err)
+		}
+	}
+	return ok
+}
+
+// removeTag 从内存中删除所有带有tag的记录，返回删除的记录数
+// 带标签的记录不会写入磁盘，不需要再检查磁盘
+func (c *cacheInstance) removeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	c.removing = true
+	n := c.cache.RemoveTag(tag)
+	c.removing = false
+	return n
+}
+
+// removeIf 从内存和磁盘中删除所有满足fn的记录，返回删除的记录数
+// 先拷贝一份key再逐个判断，fn执行的时候不持有cacheInstance的锁
+func (c *cacheInstance) removeIf(fn func(key string) bool) int {
+	c.mu.Lock()
+	var keys []string
+	if c.cache != nil {
+		c.cache.Range(func(key string, _ lru.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+	}
+	disk := c.disk
+	c.mu.Unlock()
+	n := 0
+	for _, key := range keys {
+		if fn(key) && c.remove(key) {
+			n++
+		}
+	}
+	// 被淘汰到磁盘的记录同样可能是旧的value
+	if disk != nil {
+		for _, key := range disk.Keys() {
+			if !fn(key) {
+				continue
+			}
+			if err := disk.Remove(key); err != nil {
+				log.Println("[YCache] disk remove failed:", err)
+			}
+		}
+	}
+	return n
+}
+
+// clear 删除内存中的所有记录，不会写入磁盘
+func (c *cacheInstance) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = nil
+}
+
+// bytes 获取cacheInstance当前占用的内存
+func (c *cacheInstance) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.Bytes()
+}
+
+// oldestTick 获取cacheInstance中最久未被访问记录的逻辑时钟
+func (c *cacheInstance) oldestTick() (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0, false
+	}
+	return c.cache.OldestTick()
+}
+
+// removeOldest 淘汰cacheInstance中最久未被访问的记录
+func (c *cacheInstance) removeOldest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache != nil {
+		c.cache.RemoveOldest()
+	}
+}
+
+// setOverhead 设置每条记录额外计入的内存
+func (c *cacheInstance) setOverhead(overhead int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overhead = overhead
+	if c.cache != nil {
+		c.cache.SetEntryOverhead(overhead)
+	}
+}
+
+// entryOverhead 获取每条记录额外计入的内存
+func (c *cacheInstance) entryOverhead() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.overhead
+}
+
+// entries 按照从最久未被访问到最近被访问的顺序，获取所有记录的拷贝
+func (c *cacheInstance) entries() (keys []string, values []*ByteView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return
+	}
+	c.cache.Range(func(key string, value lru.Value) bool {
+		keys = append(keys, key)
+		values = append(values, value.(*ByteView))
+		return true
+	})
+	return
+}
+
+// setDisk 设置二级磁盘缓存，返回之前的磁盘缓存，由调用方关闭
+func (c *cacheInstance) setDisk(disk *diskcache.Store) *diskcache.Store {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.disk
+	c.disk = disk
+	return old
+}
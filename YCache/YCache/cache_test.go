@@ -0,0 +1,74 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestOverheadAccounting 开启开销统计后，cache统计的内存应该接近runtime.MemStats中堆内存的增长
+func TestOverheadAccounting(t *testing.T) {
+	const n = 100000
+	g := NewGroup("overhead", 0, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	g.SetOverheadAccounting(true)
+	c := &g.mainCache
+
+	// 预先生成key，避免key的分配被计算两次
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%05d", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for _, k := range keys {
+		c.Add(k, &ByteView{b: cloneBytes([]byte("v1234567"))})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// key已经提前分配，这里的增长不包含key本身
+	grown := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	reported := c.bytes() - int64(n*len(keys[0]))
+	runtime.KeepAlive(g)
+	runtime.KeepAlive(keys)
+
+	ratio := float64(reported) / float64(grown)
+	t.Logf("heap grown %d bytes, reported %d bytes, ratio %.2f", grown, reported, ratio)
+	if ratio < 0.9 || ratio > 1.1 {
+		t.Fatalf("reported bytes %d too far from heap growth %d", reported, grown)
+	}
+}
+
+// TestDiskCache 从内存中淘汰的记录写入磁盘，cache miss的时候从磁盘中获取，不会再调用Getter
+func TestDiskCache(t *testing.T) {
+	loads := 0
+	g := NewGroup("disk", 20, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("value-" + key), nil
+	}))
+	if err := g.SetDiskCache(filepath.Join(t.TempDir(), "disk.log"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer g.CloseDiskCache()
+	// 每条记录 2 + 8 = 10 字节，内存中只能保存2条
+	for _, k := range []string{"k1", "k2", "k3"} {
+		g.Get(k)
+	}
+	if _, ok := g.mainCache.getMemory("k1"); ok {
+		t.Fatalf("k1 should be evicted from memory")
+	}
+	if view, err := g.Get("k1"); err != nil || view.String() != "value-k1" || loads != 3 {
+		t.Fatalf("k1 should be loaded from disk, loads %d", loads)
+	}
+}
@@ -0,0 +1,128 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// defaultCompressThreshold 响应体小于这个值的时候不压缩，压缩小数据得不偿失
+const defaultCompressThreshold = 1024
+
+// compressor 一种压缩算法，名称就是HTTP的Content-Encoding
+type compressor struct {
+	name      string
+	newWriter func(w io.Writer) io.WriteCloser
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	// compressors 按照优先级从高到低排列，服务端选择客户端支持的第一个
+	compressors = []*compressor{
+		{
+			name:      "gzip",
+			newWriter: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+			newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		},
+		{
+			name:      "deflate",
+			newWriter: func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw },
+			newReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+		},
+	}
+)
+
+// RegisterCompressor 注册一种压缩算法，例如zstd、snappy，后注册的优先级更高
+// 所有节点都需要注册，客户端才会在Accept-Encoding中声明支持
+func RegisterCompressor(name string, newWriter func(w io.Writer) io.WriteCloser, newReader func(r io.Reader) (io.ReadCloser, error)) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	c := &compressor{name: name, newWriter: newWriter, newReader: newReader}
+	compressors = append([]*compressor{c}, compressors...)
+}
+
+// getCompressor 基于名称获取压缩算法
+func getCompressor(name string) *compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for _, c := range compressors {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// acceptEncoding 客户端请求头中的Accept-Encoding
+func acceptEncoding() string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	names := make([]string, len(compressors))
+	for i, c := range compressors {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiate 根据客户端的Accept-Encoding选择压缩算法，没有双方都支持的算法时返回nil
+func negotiate(accept string) *compressor {
+	if accept == "" {
+		return nil
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		// 忽略q值，例如 gzip;q=0.8
+		name := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		accepted[name] = true
+	}
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for _, c := range compressors {
+		if accepted[c.name] {
+			return c
+		}
+	}
+	return nil
+}
+
+// compress 使用压缩算法c压缩b
+func compress(c *compressor, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.newWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress 使用压缩算法c解压b，maxBytes大于0的时候，解压之后超过这个大小返回错误
+func decompress(c *compressor, b []byte, maxBytes int64) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if maxBytes <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err == nil && int64(len(out)) > maxBytes {
+		err = fmt.Errorf("%w: decompressed body more than %d bytes", errResponseTooLarge, maxBytes)
+	}
+	return out, err
+}
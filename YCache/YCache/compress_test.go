@@ -0,0 +1,103 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"strings"
+	"testing"
+)
+
+// TestResponseCompression 大响应根据Accept-Encoding压缩，小响应和不支持压缩的客户端不压缩
+func TestResponseCompression(t *testing.T) {
+	big := strings.Repeat(`{"name":"Tom","score":630},`, 200)
+	NewGroup("compress", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		if key == "big" {
+			return []byte(big), nil
+		}
+		return []byte("small"), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	// 客户端和服务端一起测试，压缩的响应能够正确解压
+	for _, disable := range []bool{false, true} {
+		getter := &httpGetter{baseURL: srv.URL + defaultBasePath, disableCompression: disable}
+		res := &ycachepb.Response{}
+		if err := getter.Get(&ycachepb.Request{Group: "compress", Key: "big"}, res); err != nil || string(res.Value) != big {
+			t.Fatalf("big value corrupted: %v", err)
+		}
+	}
+
+	// 检查响应头，只有大响应并且客户端支持压缩的时候才压缩
+	testCases := []struct {
+		key, accept, encoding string
+	}{
+		{"big", "gzip", "gzip"},
+		{"big", "identity", ""},
+		{"small", "gzip", ""},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+"compress/"+tc.key, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Content-Encoding"); got != tc.encoding {
+			t.Fatalf("%s with Accept-Encoding %s: expect encoding %q, got %q", tc.key, tc.accept, tc.encoding, got)
+		}
+	}
+	if c := negotiate("br, deflate;q=0.5"); c == nil || c.name != "deflate" {
+		t.Fatalf("should negotiate deflate")
+	}
+}
+
+// TestCompressValues 压缩存储的value读取的时候透明解压，并统计压缩率
+func TestCompressValues(t *testing.T) {
+	big := strings.Repeat("630,589,567,", 100)
+	g := NewGroup("compress-values", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(big), nil
+	}))
+	g.SetCompressValues(256)
+	for i := 0; i < 2; i++ {
+		view, err := g.Get("Tom")
+		if err != nil || view.String() != big || string(view.ByteSlice()) != big {
+			t.Fatalf("compressed value should be transparent")
+		}
+	}
+	stored, _ := g.mainCache.GetValue("Tom")
+	if !stored.z || stored.Len() >= len(big) {
+		t.Fatalf("value should be stored compressed")
+	}
+	if ratio := g.Stats.CompressionRatio(); ratio <= 0 || ratio >= 0.5 {
+		t.Fatalf("unexpected compression ratio %.2f", ratio)
+	}
+}
+
+// TestCorruptCompressedValue 解压失败的记录被删除并重新加载，不会作为空value返回
+func TestCorruptCompressedValue(t *testing.T) {
+	big := strings.Repeat("630,589,567,", 100)
+	loads := 0
+	g := NewGroup("corrupt-values", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(big), nil
+	}))
+	g.SetCompressValues(256)
+	g.Get("Tom")
+	g.mainCache.Add("Tom", &ByteView{b: []byte("not gzip"), z: true})
+	view, err := g.Get("Tom")
+	if err != nil || view.String() != big {
+		t.Fatalf("corrupt value should be reloaded, got %q %v", view.String(), err)
+	}
+	if loads != 2 || g.Stats.CorruptEntries.Get() != 1 {
+		t.Fatalf("expect 2 loads and 1 corrupt entry, got %d %d", loads, g.Stats.CorruptEntries.Get())
+	}
+}
@@ -0,0 +1,443 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package config 节点的声明式配置，描述当前节点地址、节点列表、监听地址、group、节点间通信和TLS
+// 配置文件使用JSON格式，环境变量和命令行参数可以覆盖配置文件中的值
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	YCache "seven-days-projects/YCache/YCache"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config 节点配置
+type Config struct {
+	Self      string        `json:"self"`               // 当前节点的地址，例如http://localhost:8001
+	Peers     []string      `json:"peers"`              // 所有节点的地址，包括当前节点
+	Listen    Listen        `json:"listen"`             // 各个服务的监听地址
+	Groups    []GroupConfig `json:"groups"`             // 当前节点上的group
+	Transport Transport     `json:"transport"`          // 节点间通信的配置
+	TLS       TLS           `json:"tls"`                // 节点间通信的证书
+	Snapshot  string        `json:"snapshot,omitempty"` // 快照文件，启动的时候恢复，退出的时候保存
+
+	// ShutdownTimeout 收到退出信号之后，等待处理中的请求完成的最长时间，为空表示10秒
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"`
+}
+
+// Listen 监听地址，为空表示不启动对应的服务
+type Listen struct {
+	Cache    string `json:"cache,omitempty"`    // 节点间通信的地址，为空时使用Self中的host:port
+	API      string `json:"api,omitempty"`      // 对外的HTTP API
+	RESP     string `json:"resp,omitempty"`     // Redis协议
+	Memcache string `json:"memcache,omitempty"` // memcached协议
+}
+
+// GroupConfig group的配置
+type GroupConfig struct {
+	Name          string       `json:"name"`
+	CacheBytes    int64        `json:"cache_bytes"`               // mainCache最大占用内存
+	TTL           Duration     `json:"ttl,omitempty"`             // 从Getter加载的value的有效期
+	MaxEntryBytes int64        `json:"max_entry_bytes,omitempty"` // 单条记录最大内存
+	CompressBytes int          `json:"compress_bytes,omitempty"`  // value达到这个长度时压缩存储
+	HotCacheBytes int64        `json:"hot_cache_bytes,omitempty"` // hotCache最大占用内存
+	HotTTL        Duration     `json:"hot_ttl,omitempty"`         // hotCache中副本的有效期
+	Getter        GetterConfig `json:"getter"`
+}
+
+// GetterConfig 数据源配置，Type对应RegisterGetter注册的名称，Params由对应的GetterFactory解析
+type GetterConfig struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Transport 节点间通信的配置，对应YCache.HTTPPoolOptions
+type Transport struct {
+	BasePath            string   `json:"base_path,omitempty"`
+	Replicas            int      `json:"replicas,omitempty"`
+	HealthCheckInterval Duration `json:"health_check_interval,omitempty"`
+	FailureThreshold    int      `json:"failure_threshold,omitempty"`
+	OpenTimeout         Duration `json:"open_timeout,omitempty"`
+	Retry               *Retry   `json:"retry,omitempty"`
+	Hedge               bool     `json:"hedge,omitempty"`
+	HedgeDelay          Duration `json:"hedge_delay,omitempty"`
+	Secret              string   `json:"secret,omitempty"`
+	DisableCompression  bool     `json:"disable_compression,omitempty"`
+	CompressThreshold   int      `json:"compress_threshold,omitempty"`
+	StreamThreshold     int      `json:"stream_threshold,omitempty"`
+	MaxResponseBytes    int64    `json:"max_response_bytes,omitempty"`
+}
+
+// Retry 对应YCache.RetryPolicy
+type Retry struct {
+	MaxAttempts int      `json:"max_attempts"`
+	BaseDelay   Duration `json:"base_delay,omitempty"`
+	MaxDelay    Duration `json:"max_delay,omitempty"`
+	BudgetRatio float64  `json:"budget_ratio,omitempty"`
+	MinRetries  int      `json:"min_retries,omitempty"`
+}
+
+// TLS 节点间通信的证书，CAFile不为空时使用双向TLS
+type TLS struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CAFile   string `json:"ca_file,omitempty"`
+}
+
+// Duration 时间间隔，使用time.ParseDuration的格式，例如"10s"、"1m30s"，为空表示0
+// 在Validate中解析，出错的时候可以指出具体的字段
+type Duration string
+
+// Value 转换为time.Duration，格式错误的时候返回0
+func (d Duration) Value() time.Duration {
+	v, _ := d.parse()
+	return v
+}
+
+func (d Duration) parse() (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(string(d))
+}
+
+// checkDuration 校验时间间隔的格式，并且不能是负数
+func checkDuration(field string, d Duration) error {
+	v, err := d.parse()
+	if err != nil {
+		return &FieldError{field, fmt.Sprintf("%q is not a duration like \"10s\"", string(d))}
+	}
+	if v < 0 {
+		return &FieldError{field, "must not be negative"}
+	}
+	return nil
+}
+
+// FieldError 配置校验错误，Field是出错字段在配置文件中的路径，例如groups[1].cache_bytes
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	return "config: " + e.Field + ": " + e.Msg
+}
+
+// GetterFactory 根据配置中的params创建Getter
+type GetterFactory func(params json.RawMessage) (YCache.Getter, error)
+
+var (
+	gettersMu sync.RWMutex
+	getters   = map[string]GetterFactory{"static": staticGetter}
+)
+
+// RegisterGetter 注册数据源类型，配置中getter.type为name的group使用factory创建Getter
+func RegisterGetter(name string, factory GetterFactory) {
+	gettersMu.Lock()
+	defer gettersMu.Unlock()
+	getters[name] = factory
+}
+
+func getterFactory(name string) (GetterFactory, bool) {
+	gettersMu.RLock()
+	defer gettersMu.RUnlock()
+	f, ok := getters[name]
+	return f, ok
+}
+
+// staticGetter 内置的数据源，params是key到value的映射，适合测试和演示
+func staticGetter(params json.RawMessage) (YCache.Getter, error) {
+	var values map[string]string
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &values); err != nil {
+			return nil, err
+		}
+	}
+	return YCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := values[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}), nil
+}
+
+// Load 读取并解析配置文件，不认识的字段视为错误，避免拼写错误的配置被静默忽略
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse 解析JSON格式的配置
+func Parse(b []byte) (*Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	c := &Config{}
+	if err := dec.Decode(c); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, &FieldError{Field: typeErr.Field, Msg: "expected " + typeErr.Type.String() + ", got " + typeErr.Value}
+		}
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return c, nil
+}
+
+// ApplyEnv 使用环境变量覆盖配置，YCACHE_PEERS是逗号分隔的节点列表
+func (c *Config) ApplyEnv() {
+	envs := []struct {
+		name  string
+		field *string
+	}{
+		{"YCACHE_SELF", &c.Self},
+		{"YCACHE_LISTEN_CACHE", &c.Listen.Cache},
+		{"YCACHE_LISTEN_API", &c.Listen.API},
+		{"YCACHE_LISTEN_RESP", &c.Listen.RESP},
+		{"YCACHE_LISTEN_MEMCACHE", &c.Listen.Memcache},
+		{"YCACHE_SECRET", &c.Transport.Secret},
+		{"YCACHE_SNAPSHOT", &c.Snapshot},
+	}
+	for _, env := range envs {
+		if v, ok := os.LookupEnv(env.name); ok {
+			*env.field = v
+		}
+	}
+	if v, ok := os.LookupEnv("YCACHE_PEERS"); ok {
+		c.Peers = SplitList(v)
+	}
+}
+
+// SplitList 解析逗号分隔的列表，忽略空白和空项
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Validate 校验配置，返回的FieldError指出出错的字段
+func (c *Config) Validate() error {
+	if err := checkURL("self", c.Self); err != nil {
+		return err
+	}
+	if len(c.Peers) == 0 {
+		return &FieldError{"peers", "at least one peer is required"}
+	}
+	seen := make(map[string]bool)
+	for i, peer := range c.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+		if err := checkURL(field, peer); err != nil {
+			return err
+		}
+		if seen[peer] {
+			return &FieldError{field, "duplicate peer " + peer}
+		}
+		seen[peer] = true
+	}
+	if !seen[c.Self] {
+		return &FieldError{"peers", "must include self " + c.Self}
+	}
+
+	if err := checkDuration("shutdown_timeout", c.ShutdownTimeout); err != nil {
+		return err
+	}
+
+	if len(c.Groups) == 0 {
+		return &FieldError{"groups", "at least one group is required"}
+	}
+	names := make(map[string]bool)
+	for i, g := range c.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		switch {
+		case g.Name == "":
+			return &FieldError{field + ".name", "is required"}
+		case names[g.Name]:
+			return &FieldError{field + ".name", "duplicate group " + g.Name}
+		case YCache.ReservedGroupName(g.Name):
+			return &FieldError{field + ".name", g.Name + " is reserved"}
+		case g.CacheBytes < 0:
+			return &FieldError{field + ".cache_bytes", "must not be negative"}
+		case g.MaxEntryBytes < 0:
+			return &FieldError{field + ".max_entry_bytes", "must not be negative"}
+		case g.CompressBytes < 0:
+			return &FieldError{field + ".compress_bytes", "must not be negative"}
+		case g.HotCacheBytes < 0:
+			return &FieldError{field + ".hot_cache_bytes", "must not be negative"}
+		case g.Getter.Type == "":
+			return &FieldError{field + ".getter.type", "is required"}
+		}
+		if err := checkDuration(field+".ttl", g.TTL); err != nil {
+			return err
+		}
+		if err := checkDuration(field+".hot_ttl", g.HotTTL); err != nil {
+			return err
+		}
+		if _, ok := getterFactory(g.Getter.Type); !ok {
+			return &FieldError{field + ".getter.type", fmt.Sprintf("unknown getter %q, registered: %s", g.Getter.Type, strings.Join(getterTypes(), ", "))}
+		}
+		names[g.Name] = true
+	}
+
+	t := c.Transport
+	switch {
+	case t.BasePath != "" && (!strings.HasPrefix(t.BasePath, "/") || !strings.HasSuffix(t.BasePath, "/")):
+		return &FieldError{"transport.base_path", "must start and end with /"}
+	case t.Replicas < 0:
+		return &FieldError{"transport.replicas", "must not be negative"}
+	case t.FailureThreshold < 0:
+		return &FieldError{"transport.failure_threshold", "must not be negative"}
+	case t.CompressThreshold < 0:
+		return &FieldError{"transport.compress_threshold", "must not be negative"}
+	case t.StreamThreshold < 0:
+		return &FieldError{"transport.stream_threshold", "must not be negative"}
+	case t.MaxResponseBytes < 0:
+		return &FieldError{"transport.max_response_bytes", "must not be negative"}
+	}
+	durations := map[string]Duration{
+		"transport.health_check_interval": t.HealthCheckInterval,
+		"transport.open_timeout":          t.OpenTimeout,
+		"transport.hedge_delay":           t.HedgeDelay,
+	}
+	if r := t.Retry; r != nil {
+		durations["transport.retry.base_delay"] = r.BaseDelay
+		durations["transport.retry.max_delay"] = r.MaxDelay
+	}
+	for _, field := range sortedKeys(durations) {
+		if err := checkDuration(field, durations[field]); err != nil {
+			return err
+		}
+	}
+	if r := t.Retry; r != nil {
+		switch {
+		case r.MaxAttempts < 1:
+			return &FieldError{"transport.retry.max_attempts", "must be at least 1"}
+		case r.BudgetRatio < 0:
+			return &FieldError{"transport.retry.budget_ratio", "must not be negative"}
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return &FieldError{"tls", "cert_file and key_file must be set together"}
+	}
+	if c.TLS.CAFile != "" && c.TLS.CertFile == "" {
+		return &FieldError{"tls.ca_file", "requires cert_file and key_file"}
+	}
+	return nil
+}
+
+// checkURL 节点地址必须是http或者https的URL
+func checkURL(field, s string) error {
+	if s == "" {
+		return &FieldError{field, "is required"}
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &FieldError{field, fmt.Sprintf("%q is not an http(s) URL", s)}
+	}
+	return nil
+}
+
+// sortedKeys 按字典序返回map的key，保证多个字段出错时返回的错误是确定的
+func sortedKeys(m map[string]Duration) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getterTypes() []string {
+	gettersMu.RLock()
+	defer gettersMu.RUnlock()
+	var types []string
+	for name := range getters {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// CacheAddr 节点间通信的监听地址，没有配置的时候使用Self中的host:port
+func (c *Config) CacheAddr() string {
+	if c.Listen.Cache != "" {
+		return c.Listen.Cache
+	}
+	if u, err := url.Parse(c.Self); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// PoolOptions 转换为HTTPPool的配置
+func (c *Config) PoolOptions() *YCache.HTTPPoolOptions {
+	t := c.Transport
+	opts := &YCache.HTTPPoolOptions{
+		BasePath:            t.BasePath,
+		Replicas:            t.Replicas,
+		HealthCheckInterval: t.HealthCheckInterval.Value(),
+		FailureThreshold:    t.FailureThreshold,
+		OpenTimeout:         t.OpenTimeout.Value(),
+		Hedge:               t.Hedge,
+		HedgeDelay:          t.HedgeDelay.Value(),
+		CertFile:            c.TLS.CertFile,
+		KeyFile:             c.TLS.KeyFile,
+		CAFile:              c.TLS.CAFile,
+		Secret:              t.Secret,
+		DisableCompression:  t.DisableCompression,
+		CompressThreshold:   t.CompressThreshold,
+		StreamThreshold:     t.StreamThreshold,
+		MaxResponseBytes:    t.MaxResponseBytes,
+	}
+	if r := t.Retry; r != nil {
+		opts.Retry = &YCache.RetryPolicy{
+			MaxAttempts: r.MaxAttempts,
+			BaseDelay:   r.BaseDelay.Value(),
+			MaxDelay:    r.MaxDelay.Value(),
+			BudgetRatio: r.BudgetRatio,
+			MinRetries:  r.MinRetries,
+		}
+	}
+	return opts
+}
+
+// NewGroups 按照配置创建所有的group，需要先调用Validate
+func (c *Config) NewGroups() ([]*YCache.Group, error) {
+	groups := make([]*YCache.Group, 0, len(c.Groups))
+	for i, gc := range c.Groups {
+		factory, ok := getterFactory(gc.Getter.Type)
+		if !ok {
+			return nil, &FieldError{fmt.Sprintf("groups[%d].getter.type", i), "unknown getter " + gc.Getter.Type}
+		}
+		getter, err := factory(gc.Getter.Params)
+		if err != nil {
+			return nil, &FieldError{fmt.Sprintf("groups[%d].getter.params", i), err.Error()}
+		}
+		g := YCache.NewGroup(gc.Name, gc.CacheBytes, getter)
+		g.SetTTL(gc.TTL.Value())
+		g.SetMaxEntryBytes(gc.MaxEntryBytes)
+		g.SetCompressValues(gc.CompressBytes)
+		if gc.HotTTL.Value() > 0 {
+			g.SetHotCache(gc.HotCacheBytes, gc.HotTTL.Value())
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
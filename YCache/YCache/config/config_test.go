@@ -0,0 +1,99 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sample = `{
+	"self": "http://localhost:8001",
+	"peers": ["http://localhost:8001", "http://localhost:8002"],
+	"listen": {"api": "localhost:9999"},
+	"groups": [{
+		"name": "config-scores",
+		"cache_bytes": 2048,
+		"ttl": "1m",
+		"getter": {"type": "static", "params": {"Tom": "630"}}
+	}],
+	"transport": {"replicas": 10, "retry": {"max_attempts": 3, "base_delay": "10ms"}},
+	"tls": {}
+}`
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ycache.json")
+	if err := os.WriteFile(path, []byte(sample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("YCACHE_LISTEN_API", "localhost:7777")
+	defer os.Unsetenv("YCACHE_LISTEN_API")
+	c.ApplyEnv()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen.API != "localhost:7777" || c.CacheAddr() != "localhost:8001" {
+		t.Fatalf("listen addresses: api=%s cache=%s", c.Listen.API, c.CacheAddr())
+	}
+	opts := c.PoolOptions()
+	if opts.Replicas != 10 || opts.Retry == nil || opts.Retry.BaseDelay != 10*time.Millisecond {
+		t.Fatalf("unexpected pool options %+v", opts)
+	}
+	groups, err := c.NewGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := groups[0].Get("Tom"); err != nil || v.String() != "630" || v.Expire().IsZero() {
+		t.Fatalf("group from config: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch func(c *Config)
+		field string
+	}{
+		{"bad self", func(c *Config) { c.Self = "localhost:8001" }, "self"},
+		{"self not in peers", func(c *Config) { c.Peers = c.Peers[1:] }, "peers"},
+		{"duplicate peer", func(c *Config) { c.Peers = append(c.Peers, c.Peers[0]) }, "peers[2]"},
+		{"reserved group", func(c *Config) { c.Groups[0].Name = "_events" }, "groups[0].name"},
+		{"negative size", func(c *Config) { c.Groups[0].CacheBytes = -1 }, "groups[0].cache_bytes"},
+		{"bad ttl", func(c *Config) { c.Groups[0].TTL = "soon" }, "groups[0].ttl"},
+		{"unknown getter", func(c *Config) { c.Groups[0].Getter.Type = "redis" }, "groups[0].getter.type"},
+		{"bad retry", func(c *Config) { c.Transport.Retry.MaxAttempts = 0 }, "transport.retry.max_attempts"},
+		{"bad retry delay", func(c *Config) { c.Transport.Retry.MaxDelay = "-1s" }, "transport.retry.max_delay"},
+		{"key without cert", func(c *Config) { c.TLS.KeyFile = "node.key" }, "tls"},
+	}
+	for _, tt := range tests {
+		c, err := Parse([]byte(sample))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.patch(c)
+		var fieldErr *FieldError
+		if err := c.Validate(); !errors.As(err, &fieldErr) || fieldErr.Field != tt.field {
+			t.Fatalf("%s: expected error on %s, got %v", tt.name, tt.field, err)
+		}
+	}
+
+	// 类型错误和不认识的字段在解析的时候就报错
+	if _, err := Parse([]byte(`{"groups": [{"cache_bytes": "2MB"}]}`)); err == nil || !strings.Contains(err.Error(), "cache_bytes") {
+		t.Fatalf("type error should name the field, got %v", err)
+	}
+	if _, err := Parse([]byte(`{"peer": []}`)); err == nil || !strings.Contains(err.Error(), "peer") {
+		t.Fatalf("unknown field should be rejected, got %v", err)
+	}
+}
@@ -69,4 +69,27 @@ func (m *Map) Get(key string) string {
 
 	// 由于认为keys一个环形结构，idx==len(m.keys)，那么就是取第一个虚拟节点的hash值，如果idx<len(m.keys)，那么就直接从keys中取虚拟节点的hash值即可，最终从hashMap获取真实cache的IP值
 	return m.hashMap[m.keys[idx%len(m.keys)]]
-}
\ No newline at end of file
+}
+
+// GetN 基于查询的key，沿着hash环顺时针获取最多n个不同的真实节点，第一个就是Get返回的节点，后面的可以作为备用节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	// 最多绕hash环一圈
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -7,6 +7,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -57,4 +58,28 @@ func TestHashing(t *testing.T) {
 		}
 	}
 
-}
\ No newline at end of file
+}
+
+// TestGetN 沿着hash环获取多个不同的真实节点
+func TestGetN(t *testing.T) {
+	hash := NewMap(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点排序后为：02、04、06、12、14、16、22、24、26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"11": {"2", "4"},
+		"23": {"4", "6", "2"},
+		"27": {"2", "4", "6"},
+	}
+	for k, v := range testCases {
+		if got := hash.GetN(k, len(v)); !reflect.DeepEqual(got, v) {
+			t.Errorf("GetN(%s) = %v, should have yielded %v", k, got, v)
+		}
+	}
+	if got := hash.GetN("11", 5); len(got) != 3 {
+		t.Errorf("GetN should return at most 3 nodes, got %v", got)
+	}
+}
@@ -0,0 +1,421 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package diskcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"seven-days-projects/YCache/YCache/lru"
+	"sync"
+)
+
+const (
+	headerSize = 4 + 1 + 8 + 4 + 4 // crc32 + flag + expire + keyLen + valLen
+
+	flagPut    = 0 // 写入记录
+	flagDelete = 1 // 删除记录(墓碑)
+
+	// minCompactBytes 日志文件小于该值的时候不做压缩
+	minCompactBytes = 1 << 20
+	// maxRecordBytes 单条记录key和value长度之和的上限，记录头中的长度超过上限说明文件已经损坏
+	maxRecordBytes = 1 << 30
+)
+
+// errChecksum 记录的校验和不一致，记录头中的长度仍然可以用来跳过这条记录
+var errChecksum = errors.New("diskcache: checksum mismatch")
+
+// record 记录在日志文件中的位置，作为lru.Cache的value，这样磁盘上的记录也按lru算法淘汰
+type record struct {
+	offset int64 // 记录在文件中的偏移量
+	valLen int   // value的长度
+	expire int64 // 过期时间的UnixNano，0表示不过期
+}
+
+// Len 实现lru.Value接口，返回记录在文件中除key之外占用的字节数
+func (r *record) Len() int {
+	return headerSize + r.valLen
+}
+
+// entry 从日志文件中读取的一条完整记录
+type entry struct {
+	flag   byte
+	key    string
+	value  []byte
+	expire int64
+}
+
+// Store 基于追加写日志文件的磁盘缓存，内存中只保存key到文件偏移量的索引
+// 被淘汰或删除的记录从索引中删除并写入墓碑，占用的磁盘空间在后台压缩的时候回收
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	size  int64      // 日志文件当前大小
+	index *lru.Cache // key -> *record，maxBytes就是磁盘缓存的大小上限
+
+	evictErr   error          // 写入墓碑失败的错误，由触发淘汰的Put或Remove返回
+	compacting bool           // 是否正在压缩，同一时间只有一个压缩
+	closed     bool           // 已经关闭，不再开始新的压缩
+	wg         sync.WaitGroup // 等待后台压缩结束
+}
+
+// Open 打开或创建日志文件，并扫描文件重建索引，maxBytes是有效记录占用磁盘的上限，0表示不限制
+func Open(path string, maxBytes int64) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{path: path, f: f, index: lru.NewCache(maxBytes, nil)}
+	if err = s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// 重建索引的时候淘汰的记录已经在文件中，之后淘汰的记录才需要写入墓碑
+	s.index.OnEvicted = s.evicted
+	return s, nil
+}
+
+// load 扫描日志文件重建索引，文件末尾没有写完整的记录会被截断
+// 中间校验和不一致的记录被跳过，同一个key之前的记录也一起作废；记录头中的长度不合法说明文件已经损坏，返回错误
+func (s *Store) load() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+	r := bufio.NewReader(s.f)
+	var offset int64
+	for offset < fileSize {
+		e, n, err := readRecord(r, fileSize-offset)
+		if err == io.ErrUnexpectedEOF {
+			// 最后一条记录没有写完整，截断之后继续使用
+			if err = s.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		switch {
+		case errors.Is(err, errChecksum):
+			s.index.Remove(e.key)
+		case err != nil:
+			return fmt.Errorf("diskcache: %s at offset %d: %w", s.path, offset, err)
+		case e.flag == flagDelete:
+			s.index.Remove(e.key)
+		default:
+			s.index.Add(e.key, &record{offset: offset, valLen: len(e.value), expire: e.expire})
+		}
+		offset += n
+	}
+	s.size = offset
+	_, err = s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord 从r中读取一条记录，返回记录占用的字节数，limit是r中剩余的字节数
+// 记录头中的长度先和limit、maxRecordBytes比较之后再分配内存，超出limit的记录返回io.ErrUnexpectedEOF
+func readRecord(r io.Reader, limit int64) (e entry, n int64, err error) {
+	var header [headerSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	e.flag = header[4]
+	e.expire = int64(binary.BigEndian.Uint64(header[5:13]))
+	keyLen := int64(binary.BigEndian.Uint32(header[13:17]))
+	valLen := int64(binary.BigEndian.Uint32(header[17:21]))
+	if keyLen+valLen > maxRecordBytes {
+		err = fmt.Errorf("diskcache: record length %d exceeds %d", keyLen+valLen, maxRecordBytes)
+		return
+	}
+	if headerSize+keyLen+valLen > limit {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	body := make([]byte, keyLen+valLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	n = headerSize + keyLen + valLen
+	e.key = string(body[:keyLen])
+	e.value = body[keyLen:]
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		err = errChecksum
+	}
+	return
+}
+
+// readAt 读取索引中key对应的记录
+func readAt(f *os.File, key string, rec *record) ([]byte, error) {
+	size := int64(headerSize + len(key) + rec.valLen)
+	e, _, err := readRecord(io.NewSectionReader(f, rec.offset, size), size)
+	if err != nil {
+		return nil, err
+	}
+	if e.key != key || len(e.value) != rec.valLen {
+		return nil, fmt.Errorf("diskcache: record at offset %d does not match key %q", rec.offset, key)
+	}
+	return e.value, nil
+}
+
+// encodeRecord 编码一条记录
+func encodeRecord(flag byte, key string, value []byte, expire int64) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = flag
+	binary.BigEndian.PutUint64(buf[5:13], uint64(expire))
+	binary.BigEndian.PutUint32(buf[13:17], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// append 追加写入一条记录，返回记录的偏移量
+func (s *Store) append(flag byte, key string, value []byte, expire int64) (int64, error) {
+	buf := encodeRecord(flag, key, value, expire)
+	offset := s.size
+	if _, err := s.f.Write(buf); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(buf))
+	return offset, nil
+}
+
+// evicted 记录从索引中删除的回调，按大小上限淘汰和主动删除都会写入一条墓碑
+// 读取时的访问顺序不会写入日志，重新打开时重放的淘汰结果可能不同，没有墓碑的话已经淘汰的旧记录会复活
+func (s *Store) evicted(key string, _ lru.Value) {
+	if _, err := s.append(flagDelete, key, nil, 0); err != nil && s.evictErr == nil {
+		s.evictErr = err
+	}
+}
+
+// takeEvictErr 获取并清除写入墓碑失败的错误
+func (s *Store) takeEvictErr() error {
+	err := s.evictErr
+	s.evictErr = nil
+	return err
+}
+
+// Put 写入记录，expire是过期时间的UnixNano，0表示不过期
+func (s *Store) Put(key string, value []byte, expire int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, err := s.append(flagPut, key, value, expire)
+	if err != nil {
+		return err
+	}
+	s.index.Add(key, &record{offset: offset, valLen: len(value), expire: expire})
+	s.maybeCompact()
+	return s.takeEvictErr()
+}
+
+// Get 读取记录，返回value和过期时间
+func (s *Store) Get(key string) (value []byte, expire int64, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.index.GetValue(key)
+	if !ok {
+		return
+	}
+	rec := v.(*record)
+	if value, err = readAt(s.f, key, rec); err != nil {
+		s.index.Remove(key)
+		s.takeEvictErr()
+		return nil, 0, false, err
+	}
+	return value, rec.expire, true, nil
+}
+
+// Remove 删除记录，会写入一条墓碑记录，保证重新打开文件之后记录不会复活
+func (s *Store) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index.Remove(key)
+	s.maybeCompact()
+	return s.takeEvictErr()
+}
+
+// Keys 获取所有有效记录的key，按照从最久未被访问到最近被访问的顺序
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, s.index.Len())
+	s.index.Range(func(key string, _ lru.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Bytes 获取有效记录占用的磁盘空间
+func (s *Store) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.index.Bytes()
+}
+
+// FileSize 获取日志文件的大小，包括还没有被压缩回收的空间
+func (s *Store) FileSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// maybeCompact 无效记录超过一半的时候在后台压缩日志文件，调用方需要持有s.mu
+// Put和Remove可能在调用方持有其他锁的时候执行，压缩不能在调用方的goroutine中进行
+func (s *Store) maybeCompact() {
+	if s.compacting || s.closed || s.size < minCompactBytes || s.size < 2*s.index.Bytes() {
+		return
+	}
+	s.compacting = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.compact(); err != nil {
+			log.Println("[YCache] disk compact failed:", err)
+		}
+	}()
+}
+
+// Compact 将有效记录重写到新文件，回收被淘汰和删除的记录占用的空间，后台压缩正在进行的时候等待它完成
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return os.ErrClosed
+	}
+	if s.compacting {
+		s.mu.Unlock()
+		s.wg.Wait()
+		return nil
+	}
+	s.compacting = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+	return s.compact()
+}
+
+// compact 压缩日志文件，只在开始和结束的时候持有s.mu，复制有效记录的时候不阻塞读写
+// 复制期间追加的记录在结束的时候原样复制到新文件末尾，调用方需要先设置compacting
+func (s *Store) compact() error {
+	s.mu.Lock()
+	var (
+		keys []string
+		recs []*record
+	)
+	s.index.Range(func(key string, v lru.Value) bool {
+		keys = append(keys, key)
+		recs = append(recs, v.(*record))
+		return true
+	})
+	src, end := s.f, s.size
+	s.mu.Unlock()
+
+	tmp, offsets, size, err := s.rewrite(src, keys, recs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compacting = false
+	if err != nil {
+		return err
+	}
+	return s.swap(tmp, offsets, size, end)
+}
+
+// rewrite 将记录按照从最久未被访问到最近被访问的顺序写入临时文件，重新打开的时候lru顺序不变
+// 返回每条记录在临时文件中的偏移量和临时文件的大小
+func (s *Store) rewrite(src *os.File, keys []string, recs []*record) (*os.File, map[*record]int64, int64, error) {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	w := bufio.NewWriter(tmp)
+	var (
+		offset  int64
+		offsets = make(map[*record]int64, len(recs))
+	)
+	for i, rec := range recs {
+		var value []byte
+		if value, err = readAt(src, keys[i], rec); err != nil {
+			break
+		}
+		buf := encodeRecord(flagPut, keys[i], value, rec.expire)
+		if _, err = w.Write(buf); err != nil {
+			break
+		}
+		offsets[rec] = offset
+		offset += int64(len(buf))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return nil, nil, 0, err
+	}
+	return tmp, offsets, offset, nil
+}
+
+// swap 把压缩开始之后追加的记录复制到临时文件末尾，再用临时文件替换日志文件，调用方需要持有s.mu
+// end是压缩开始时日志文件的大小，之后追加的记录偏移量整体平移
+func (s *Store) swap(tmp *os.File, offsets map[*record]int64, size, end int64) error {
+	tmpPath := tmp.Name()
+	_, err := io.Copy(tmp, io.NewSectionReader(s.f, end, s.size-end))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	delta := size - end
+	s.index.Range(func(_ string, v lru.Value) bool {
+		rec := v.(*record)
+		if off, ok := offsets[rec]; ok {
+			rec.offset = off
+		} else {
+			rec.offset += delta
+		}
+		return true
+	})
+	s.f.Close()
+	s.f = tmp
+	s.size += delta
+	_, err = s.f.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// Close 等待后台压缩结束之后关闭日志文件
+func (s *Store) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,210 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package diskcache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestPutGet 测试写入、读取、删除，以及重新打开文件之后索引能够恢复
+func TestPutGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("k1", []byte("v1"), 0)
+	s.Put("k2", []byte("v2"), 100)
+	s.Put("k1", []byte("v1-new"), 0)
+	s.Remove("k2")
+	if v, _, ok, err := s.Get("k1"); err != nil || !ok || string(v) != "v1-new" {
+		t.Fatalf("get k1 failed")
+	}
+	s.Close()
+
+	s, err = Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _, ok, _ := s.Get("k1"); !ok || string(v) != "v1-new" {
+		t.Fatalf("k1 should survive reopen")
+	}
+	if _, _, ok, _ := s.Get("k2"); ok {
+		t.Fatalf("k2 should stay removed after reopen")
+	}
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "k1" {
+		t.Fatalf("Keys() = %v", keys)
+	}
+}
+
+// TestTruncatedTail 文件末尾不完整的记录在打开的时候被截断
+func TestTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	s, _ := Open(path, 0)
+	s.Put("k1", []byte("v1"), 0)
+	s.Put("k2", []byte("v2"), 0)
+	size := s.FileSize()
+	s.Close()
+	os.Truncate(path, size-1)
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, ok, _ := s.Get("k1"); !ok {
+		t.Fatalf("k1 should be kept")
+	}
+	if _, _, ok, _ := s.Get("k2"); ok {
+		t.Fatalf("truncated k2 should be dropped")
+	}
+	s.Put("k3", []byte("v3"), 0)
+	if v, _, ok, _ := s.Get("k3"); !ok || string(v) != "v3" {
+		t.Fatalf("append after truncate failed")
+	}
+}
+
+// TestCompact 测试大小上限和压缩
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	// 每条记录 21 + 2 + 2 = 25 字节，最多保存4条
+	s, _ := Open(path, 100)
+	defer s.Close()
+	for _, k := range []string{"k1", "k2", "k3", "k4", "k5", "k6"} {
+		s.Put(k, []byte("vv"), 0)
+	}
+	if _, _, ok, _ := s.Get("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.FileSize() != s.Bytes() || s.Bytes() != 100 {
+		t.Fatalf("compact should reclaim space, file %d, live %d", s.FileSize(), s.Bytes())
+	}
+	for _, k := range []string{"k3", "k4", "k5", "k6"} {
+		if v, _, ok, _ := s.Get(k); !ok || string(v) != "vv" {
+			t.Fatalf("%s lost after compact", k)
+		}
+	}
+}
+
+// TestEvictedStaysRemoved 被淘汰的记录写入墓碑，删除之后重新打开文件不会复活
+// 读取改变了lru顺序，但访问顺序不会写入日志，没有墓碑的话重放会淘汰k1而保留k2
+func TestEvictedStaysRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	// 每条记录 21 + 2 + 2 = 25 字节，最多保存2条
+	s, _ := Open(path, 50)
+	s.Put("k1", []byte("v1"), 0)
+	s.Put("k2", []byte("v2"), 0)
+	s.Get("k1")
+	s.Put("k3", []byte("v3"), 0)
+	if _, _, ok, _ := s.Get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+	s.Remove("k2")
+	s.Close()
+
+	s, err := Open(path, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, ok, _ := s.Get("k2"); ok {
+		t.Fatalf("evicted k2 should not come back after reopen")
+	}
+	if v, _, ok, _ := s.Get("k3"); !ok || string(v) != "v3" {
+		t.Fatalf("k3 should survive reopen")
+	}
+}
+
+// TestCorruptRecord 中间损坏的记录被跳过，之后的记录保留，文件不会被截断
+func TestCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	s, _ := Open(path, 0)
+	s.Put("k1", []byte("v1"), 0)
+	s.Put("k2", []byte("v2"), 0)
+	size := s.FileSize()
+	s.Close()
+
+	// 修改k1的value
+	data, _ := os.ReadFile(path)
+	data[headerSize+2] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, ok, _ := s.Get("k1"); ok {
+		t.Fatalf("corrupt k1 should be dropped")
+	}
+	if v, _, ok, _ := s.Get("k2"); !ok || string(v) != "v2" {
+		t.Fatalf("k2 after the corrupt record should be kept")
+	}
+	if s.FileSize() != size {
+		t.Fatalf("file truncated to %d, want %d", s.FileSize(), size)
+	}
+}
+
+// TestCorruptLength 记录头中的长度超过上限的时候不分配内存，直接返回错误
+func TestCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	s, _ := Open(path, 0)
+	s.Put("k1", []byte("v1"), 0)
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	copy(data[17:21], []byte{0xff, 0xff, 0xff, 0xff})
+	os.WriteFile(path, data, 0644)
+
+	if s, err := Open(path, 0); err == nil {
+		s.Close()
+		t.Fatalf("open should fail on a corrupt record length")
+	}
+}
+
+// TestCompactConcurrent 压缩的时候继续写入，压缩期间追加的记录不会丢失
+func TestCompactConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	s, _ := Open(path, 0)
+	for i := 0; i < 100; i++ {
+		s.Put("old"+strconv.Itoa(i), []byte("v"), 0)
+		s.Remove("old" + strconv.Itoa(i))
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.Put("new"+strconv.Itoa(i), []byte("v"+strconv.Itoa(i)), 0)
+		}
+	}()
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	s.Close()
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 100; i++ {
+		if v, _, ok, _ := s.Get("new" + strconv.Itoa(i)); !ok || string(v) != "v"+strconv.Itoa(i) {
+			t.Fatalf("new%d lost after compact", i)
+		}
+	}
+	if _, _, ok, _ := s.Get("old0"); ok {
+		t.Fatalf("removed old0 should stay removed")
+	}
+}
@@ -0,0 +1,159 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// membershipPath 节点宣布离开和重新加入的路由，在basePath之下，group不能使用这个名称
+	membershipPath = "_peers/"
+	leaveAction    = "leave"
+	joinAction     = "join"
+)
+
+// Draining 当前节点是否正在下线
+func (p *HTTPPool) Draining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
+
+// Leave 当前节点开始下线：健康检查返回503，并通知其他节点把当前节点从hash环中移除
+// 已经在处理的请求不受影响，调用方在Leave之后再关闭HTTP服务，等待请求处理完成
+func (p *HTTPPool) Leave(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 1)
+	return p.announce(ctx, leaveAction)
+}
+
+// Join 通知其他节点把当前节点重新加入hash环，节点重启之后调用
+func (p *HTTPPool) Join(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 0)
+	return p.announce(ctx, joinAction)
+}
+
+// announce 并发通知所有其他节点，返回第一个失败的错误
+func (p *HTTPPool) announce(ctx context.Context, action string) error {
+	return p.broadcast("announce "+action, func(peer string) error {
+		return p.notify(ctx, peer, action)
+	})
+}
+
+// broadcast 对所有其他节点并发执行send，返回第一个失败的错误，what用于错误信息
+func (p *HTTPPool) broadcast(what string, send func(peer string) error) error {
+	p.mu.Lock()
+	var peers []string
+	for _, peer := range p.members {
+		if peer != p.self {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := send(peer); err != nil {
+				errMu.Lock()
+				if first == nil {
+					first = fmt.Errorf("%s to %s: %v", what, peer, err)
+				}
+				errMu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return first
+}
+
+// notify 通知一个节点，节点地址放在query参数中，签名可以覆盖到
+func (p *HTTPPool) notify(ctx context.Context, peer, action string) error {
+	return p.post(ctx, peer, membershipPath+action, url.Values{"peer": {p.self}})
+}
+
+// post 向节点basePath之下的path发送POST请求，请求和普通请求一样签名，参数都放在query中
+func (p *HTTPPool) post(ctx context.Context, peer, path string, query url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		peer+p.basePath+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if p.opts.Secret != "" {
+		signRequest(req, p.opts.Secret)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// membershipHandler 处理其他节点的离开和加入通知，只接受Set中设置过的节点
+func (p *HTTPPool) membershipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, p.basePath+membershipPath)
+	if action != leaveAction && action != joinAction {
+		http.Error(w, "unknown action: "+action, http.StatusNotFound)
+		return
+	}
+	peer := r.URL.Query().Get("peer")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := false
+	for _, member := range p.members {
+		known = known || member == peer
+	}
+	if !known || peer == p.self {
+		http.Error(w, "unknown peer: "+peer, http.StatusBadRequest)
+		return
+	}
+	if action == leaveAction && !p.departed[peer] {
+		if p.departed == nil {
+			p.departed = make(map[string]bool)
+		}
+		p.departed[peer] = true
+		p.rebuild()
+		p.Log("peer %s left", peer)
+	} else if action == joinAction && p.departed[peer] {
+		delete(p.departed, peer)
+		p.rebuild()
+		p.Log("peer %s joined", peer)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejoin 把宣布离开的节点重新加入hash环，返回节点是否还在离开的列表中
+func (p *HTTPPool) rejoin(peer string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.departed[peer] {
+		return false
+	}
+	delete(p.departed, peer)
+	p.rebuild()
+	return true
+}
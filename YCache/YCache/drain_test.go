@@ -0,0 +1,112 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLeaveAndJoin 节点下线之前通知其他节点把自己从hash环中移除，重新加入之后恢复
+func TestLeaveAndJoin(t *testing.T) {
+	var poolA, poolB *HTTPPool
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { poolA.ServeHTTP(w, r) }))
+	defer srvA.Close()
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { poolB.ServeHTTP(w, r) }))
+	defer srvB.Close()
+	poolA, _ = NewHTTPPoolOpts(srvA.URL, &HTTPPoolOptions{Secret: "s3cret"})
+	poolB, _ = NewHTTPPoolOpts(srvB.URL, &HTTPPoolOptions{Secret: "s3cret"})
+	poolA.Set(srvA.URL, srvB.URL)
+	poolB.Set(srvA.URL, srvB.URL)
+
+	// B上有一部分key属于A
+	ownedByA := func() int {
+		n := 0
+		for i := 0; i < 100; i++ {
+			if _, ok := poolB.PickPeer(strconv.Itoa(i)); ok {
+				n++
+			}
+		}
+		return n
+	}
+	if ownedByA() == 0 {
+		t.Fatalf("some keys should belong to A")
+	}
+
+	if err := poolA.Leave(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := ownedByA(); n != 0 {
+		t.Fatalf("B should stop routing to A after it left, still %d keys", n)
+	}
+	res, err := http.Get(srvA.URL + defaultHealthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("draining node should fail health checks, got %d", res.StatusCode)
+	}
+
+	if err := poolA.Join(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ownedByA() == 0 || poolA.Draining() {
+		t.Fatalf("A should be back in the ring after it joined")
+	}
+
+	// 只接受Set中设置过的节点，并且请求必须签名
+	req, _ := http.NewRequest(http.MethodPost, srvB.URL+defaultBasePath+membershipPath+leaveAction+"?peer=http://evil", nil)
+	signRequest(req, "s3cret")
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown peer should be rejected: %v", err)
+	}
+	res, err = http.Post(srvB.URL+defaultBasePath+membershipPath+leaveAction+"?peer="+srvA.URL, "", nil)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned announcement should be rejected: %v", err)
+	}
+}
+
+// TestDepartedPeerProbed 离开的节点没有通知加入，主动健康检查探测到它恢复之后重新加入hash环
+func TestDepartedPeerProbed(t *testing.T) {
+	var down int32 = 1
+	peer := flakyPeer(&down)
+	defer peer.Close()
+
+	self := "http://self.invalid"
+	p, _ := NewHTTPPoolOpts(self, &HTTPPoolOptions{HealthCheckInterval: 10 * time.Millisecond})
+	defer p.Stop()
+	p.Set(self, peer.URL)
+	p.mu.Lock()
+	p.departed = map[string]bool{peer.URL: true}
+	p.rebuild()
+	p.mu.Unlock()
+
+	inRing := func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		_, ok := p.httpGetters[peer.URL]
+		return ok
+	}
+	time.Sleep(50 * time.Millisecond)
+	if inRing() {
+		t.Fatalf("unreachable departed peer should stay out of the ring")
+	}
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(time.Second)
+	for !inRing() {
+		if time.Now().After(deadline) {
+			t.Fatalf("departed peer should rejoin after it passes health checks")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package getters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	YCache "seven-days-projects/YCache/YCache"
+	"strings"
+)
+
+// errBadPath key对应的路径不在Root之下
+var errBadPath = errors.New("path escapes root")
+
+// FileGetter 把key作为Root之下的相对路径，读取文件内容作为value
+// 包含..、绝对路径的key，以及通过符号链接指向Root之外的文件都会被拒绝
+type FileGetter struct {
+	Root     string
+	MaxBytes int64 // 文件的最大长度，0表示不限制
+}
+
+// NewFileGetter 创建读取Root之下文件的Getter
+func NewFileGetter(root string) *FileGetter {
+	return &FileGetter{Root: root}
+}
+
+// Get 实现Getter接口，文件不存在的时候返回ErrNotFound
+func (g *FileGetter) Get(key string) ([]byte, error) {
+	path, err := g.resolve(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+		}
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}
+	if g.MaxBytes <= 0 {
+		return ioutil.ReadAll(f)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(f, g.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > g.MaxBytes {
+		return nil, fmt.Errorf("%s: file larger than %d bytes", key, g.MaxBytes)
+	}
+	return b, nil
+}
+
+// resolve 把key转换为Root之下的真实路径
+func (g *FileGetter) resolve(key string) (string, error) {
+	// key使用/分隔，不允许空的路径段、.和..，也不允许反斜杠，避免在windows上被当作分隔符
+	if key == "" || strings.ContainsAny(key, "\\\x00") || strings.HasPrefix(key, "/") {
+		return "", errBadPath
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errBadPath
+		}
+	}
+	root, err := filepath.EvalSymlinks(g.Root)
+	if err != nil {
+		return "", err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	// 符号链接可能指向Root之外，解析之后再检查一次
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errBadPath
+	}
+	return path, nil
+}
+
+var _ YCache.Getter = (*FileGetter)(nil)
@@ -0,0 +1,48 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package getters
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	YCache "seven-days-projects/YCache/YCache"
+	"testing"
+)
+
+func TestFileGetter(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	os.MkdirAll(filepath.Join(root, "scores"), 0755)
+	os.WriteFile(filepath.Join(root, "scores", "Tom"), []byte("630"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("password"), 0644)
+	// 指向root之外的符号链接
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	g := NewFileGetter(root)
+	if v, err := g.Get("scores/Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("get scores/Tom: %q %v", v, err)
+	}
+	if _, err := g.Get("scores/Sam"); !errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("missing file should be ErrNotFound, got %v", err)
+	}
+	if _, err := g.Get("scores"); !errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("directory should be ErrNotFound, got %v", err)
+	}
+	for _, key := range []string{"../secret", "scores/../../secret", "/etc/passwd", "scores//Tom", "link", `..\secret`} {
+		if v, err := g.Get(key); err == nil || errors.Is(err, YCache.ErrNotFound) {
+			t.Fatalf("%q should be rejected, got %q %v", key, v, err)
+		}
+	}
+
+	g.MaxBytes = 2
+	if _, err := g.Get("scores/Tom"); err == nil {
+		t.Fatalf("file larger than MaxBytes should be rejected")
+	}
+}
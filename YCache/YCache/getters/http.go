@@ -0,0 +1,90 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package getters
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	YCache "seven-days-projects/YCache/YCache"
+	"strings"
+	"time"
+)
+
+// keyPlaceholder URL模板中key的占位符
+const keyPlaceholder = "{key}"
+
+// HTTPGetter 请求上游HTTP服务获取value，URL模板中的{key}替换为转义之后的key，例如
+//
+//	http://upstream/scores/{key}
+type HTTPGetter struct {
+	URL      string
+	Client   *http.Client  // 发送请求的客户端，NewHTTPGetter创建一个所有请求共用的客户端，为nil的时候使用http.DefaultClient
+	Timeout  time.Duration // 单次请求的超时时间，包括读取响应体，0表示使用默认的5秒
+	MaxBytes int64         // 响应体的最大长度，0表示不限制
+	Header   http.Header   // 每个请求都带上的header，例如认证信息
+}
+
+// NewHTTPGetter 创建请求上游HTTP服务的Getter，urlTemplate中必须包含{key}
+func NewHTTPGetter(urlTemplate string) (*HTTPGetter, error) {
+	if !strings.Contains(urlTemplate, keyPlaceholder) {
+		return nil, fmt.Errorf("url template %q has no %s", urlTemplate, keyPlaceholder)
+	}
+	if _, err := url.Parse(strings.Replace(urlTemplate, keyPlaceholder, "k", -1)); err != nil {
+		return nil, err
+	}
+	// 客户端在所有请求之间复用，保持和上游的连接，超时时间由每个请求的context控制
+	return &HTTPGetter{URL: urlTemplate, Client: &http.Client{}}, nil
+}
+
+// Get 实现Getter接口，上游返回404的时候返回ErrNotFound，其他非2xx状态码视为错误
+func (g *HTTPGetter) Get(key string) ([]byte, error) {
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.Replace(g.URL, keyPlaceholder, url.PathEscape(key), -1), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range g.Header {
+		req.Header[name] = values
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: upstream returned %v", key, res.Status)
+	}
+	if g.MaxBytes <= 0 {
+		return ioutil.ReadAll(res.Body)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, g.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > g.MaxBytes {
+		return nil, fmt.Errorf("%s: response larger than %d bytes", key, g.MaxBytes)
+	}
+	return b, nil
+}
+
+var _ YCache.Getter = (*HTTPGetter)(nil)
@@ -0,0 +1,61 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package getters
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	YCache "seven-days-projects/YCache/YCache"
+	"testing"
+	"time"
+)
+
+func TestHTTPGetter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/scores/Tom":
+			w.Write([]byte("630"))
+		case "/scores/a%2Fb":
+			w.Write([]byte(r.Header.Get("Authorization")))
+		case "/scores/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/scores/broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := NewHTTPGetter(srv.URL + "/scores"); err == nil {
+		t.Fatalf("template without {key} should be rejected")
+	}
+	g, err := NewHTTPGetter(srv.URL + "/scores/{key}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Timeout = 50 * time.Millisecond
+	g.Header = http.Header{"Authorization": {"Bearer token"}}
+
+	if v, err := g.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("get Tom: %q %v", v, err)
+	}
+	// key中的/被转义，不会改变请求的路径
+	if v, err := g.Get("a/b"); err != nil || string(v) != "Bearer token" {
+		t.Fatalf("get a/b: %q %v", v, err)
+	}
+	if _, err := g.Get("Sam"); !errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("404 should be ErrNotFound, got %v", err)
+	}
+	if _, err := g.Get("broken"); err == nil || errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("500 should be an error, got %v", err)
+	}
+	if _, err := g.Get("slow"); err == nil {
+		t.Fatalf("slow upstream should time out")
+	}
+}
@@ -0,0 +1,58 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package getters 常用数据源的Getter实现：数据库查询、本地文件和HTTP上游服务
+// 数据源中不存在的key返回包装了YCache.ErrNotFound的错误
+package getters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	YCache "seven-days-projects/YCache/YCache"
+	"time"
+)
+
+// defaultTimeout 访问数据源的默认超时时间
+const defaultTimeout = 5 * time.Second
+
+// SQLGetter 使用参数化查询从数据库中获取value
+// Query只有一个占位符，对应key，查询结果的第一行第一列就是value，例如
+//
+//	SELECT score FROM scores WHERE name = ?
+type SQLGetter struct {
+	DB      *sql.DB
+	Query   string
+	Timeout time.Duration // 单次查询的超时时间，0表示使用默认的5秒
+}
+
+// NewSQLGetter 创建数据库查询的Getter
+func NewSQLGetter(db *sql.DB, query string) *SQLGetter {
+	return &SQLGetter{DB: db, Query: query}
+}
+
+// Get 实现Getter接口，查询不到数据的时候返回ErrNotFound
+func (g *SQLGetter) Get(key string) ([]byte, error) {
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var value []byte
+	err := g.DB.QueryRowContext(ctx, g.Query, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %v", key, err)
+	}
+	return value, nil
+}
+
+var _ YCache.Getter = (*SQLGetter)(nil)
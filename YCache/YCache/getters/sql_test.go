@@ -0,0 +1,87 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package getters
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	YCache "seven-days-projects/YCache/YCache"
+	"testing"
+)
+
+// fakeDriver 测试用的数据库驱动，代替内存中的sqlite，查询结果就是table中key对应的value
+type fakeDriver struct {
+	table   map[string]string
+	queries []string
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.queries = append(c.d.queries, query)
+	return &fakeStmt{c.d}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return 1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &fakeRows{}
+	if v, ok := s.d.table[args[0].(string)]; ok {
+		rows.values = []string{v}
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ values []string }
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = []byte(r.values[0]), r.values[1:]
+	return nil
+}
+
+func TestSQLGetter(t *testing.T) {
+	d := &fakeDriver{table: map[string]string{"Tom": "630"}}
+	sql.Register("getters-fake", d)
+	db, err := sql.Open("getters-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	g := NewSQLGetter(db, "SELECT score FROM scores WHERE name = ?")
+	if v, err := g.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("get Tom: %q %v", v, err)
+	}
+	if _, err := g.Get("Sam"); !errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("missing row should be ErrNotFound, got %v", err)
+	}
+	// key只作为查询参数传递，不会拼接到SQL中
+	if _, err := g.Get("x' OR '1'='1"); !errors.Is(err, YCache.ErrNotFound) {
+		t.Fatalf("key must be passed as a parameter, got %v", err)
+	}
+	for _, q := range d.queries {
+		if q != g.Query {
+			t.Fatalf("unexpected query %q", q)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthPath       = "/healthz"
+	defaultFailureThreshold = 3
+	defaultOpenTimeout      = 5 * time.Second
+	defaultProbeTimeout     = time.Second
+)
+
+// breakerState 熔断器状态
+type breakerState int
+
+const (
+	stateClosed   breakerState = iota // 正常，请求可以发往该节点
+	stateOpen                         // 熔断，请求不会发往该节点
+	stateHalfOpen                     // 半开，只允许一次探测，成功之后恢复正常
+)
+
+// peerHealth 记录一个节点的健康状态，实现一个简单的熔断器
+type peerHealth struct {
+	mu          sync.Mutex
+	state       breakerState
+	failures    int       // 连续失败的次数
+	openedAt    time.Time // 进入熔断的时间
+	threshold   int       // 连续失败多少次之后熔断
+	openTimeout time.Duration
+}
+
+func newPeerHealth(threshold int, openTimeout time.Duration) *peerHealth {
+	return &peerHealth{threshold: threshold, openTimeout: openTimeout}
+}
+
+// allow 判断请求能否发往该节点，熔断超时之后进入半开状态，只放行一次探测请求
+func (h *peerHealth) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case stateClosed:
+		return true
+	case stateOpen:
+		if time.Since(h.openedAt) >= h.openTimeout {
+			h.state = stateHalfOpen
+			return true
+		}
+	}
+	return false
+}
+
+// healthy 判断节点是否处于正常状态，不会改变熔断器的状态
+func (h *peerHealth) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state == stateClosed
+}
+
+// success 请求或者探测成功，恢复正常状态
+func (h *peerHealth) success() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = stateClosed
+	h.failures = 0
+}
+
+// failure 请求或者探测失败，连续失败次数达到阈值，或者半开状态下探测失败，进入熔断状态
+func (h *peerHealth) failure() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	if h.state == stateHalfOpen || h.failures >= h.threshold {
+		h.state = stateOpen
+		h.openedAt = time.Now()
+	}
+}
+
+// healthHandler 节点的健康检查接口
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// probe 主动探测节点的健康检查接口
+func probe(client *http.Client, peer string) bool {
+	res, err := client.Get(peer + defaultHealthPath)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
+
+// probeAll 探测所有节点，处于熔断状态并且还没有到超时时间的节点跳过
+// 宣布离开的节点也会探测，重启之后通知加入失败的节点在健康检查通过之后重新加入hash环，下线中的节点返回503，不会被加入
+func (p *HTTPPool) probeAll(client *http.Client) {
+	p.mu.Lock()
+	health := make(map[string]*peerHealth, len(p.health))
+	for peer, h := range p.health {
+		if peer != p.self {
+			health[peer] = h
+		}
+	}
+	departed := make([]string, 0, len(p.departed))
+	for peer := range p.departed {
+		departed = append(departed, peer)
+	}
+	p.mu.Unlock()
+
+	for _, peer := range departed {
+		if probe(client, peer) && p.rejoin(peer) {
+			p.Log("peer %s is back", peer)
+		}
+	}
+
+	for peer, h := range health {
+		if !h.healthy() && !h.allow() {
+			continue
+		}
+		if probe(client, peer) {
+			h.success()
+		} else {
+			p.Log("health check %s failed", peer)
+			h.failure()
+		}
+	}
+}
+
+// healthCheckLoop 定时主动探测所有节点，直到Stop被调用
+func (p *HTTPPool) healthCheckLoop(interval time.Duration) {
+	client := &http.Client{Transport: p.client.Transport, Timeout: defaultProbeTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.probeAll(client)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Stop 停止主动健康检查
+func (p *HTTPPool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
@@ -0,0 +1,106 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyPeer 测试用的节点，down为1的时候直接断开连接，模拟节点宕机
+func flakyPeer(down *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(down) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		healthHandler(w, r)
+	}))
+}
+
+// keyOwnedBy 找到一个所属节点是owner的key
+func keyOwnedBy(p *HTTPPool, owner string) string {
+	for i := 0; ; i++ {
+		key := "key" + strconv.Itoa(i)
+		if p.peers.Get(key) == owner {
+			return key
+		}
+	}
+}
+
+// TestCircuitBreaker 连续失败之后熔断，PickPeer跳过该节点，半开探测成功之后恢复
+func TestCircuitBreaker(t *testing.T) {
+	var down int32 = 1
+	peer := flakyPeer(&down)
+	defer peer.Close()
+
+	self := "http://self.invalid"
+	p, _ := NewHTTPPoolOpts(self, &HTTPPoolOptions{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	defer p.Stop()
+	p.Set(self, peer.URL)
+	key := keyOwnedBy(p, peer.URL)
+
+	for i := 0; i < 2; i++ {
+		getter, ok := p.PickPeer(key)
+		if !ok {
+			t.Fatalf("peer should be picked before the breaker opens")
+		}
+		if err := getter.Get(&ycachepb.Request{Group: "scores", Key: key}, &ycachepb.Response{}); err == nil {
+			t.Fatalf("request to a down peer should fail")
+		}
+	}
+	// 熔断之后，只剩自己，从本地加载
+	if _, ok := p.PickPeer(key); ok {
+		t.Fatalf("open breaker should skip the peer")
+	}
+
+	// 节点恢复，等待熔断超时之后，半开状态放行一次请求
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := p.PickPeer(key); !ok {
+		t.Fatalf("half-open breaker should allow a trial request")
+	}
+	if _, ok := p.PickPeer(key); ok {
+		t.Fatalf("half-open breaker should allow only one trial request")
+	}
+}
+
+// TestActiveHealthCheck 主动探测发现节点宕机和恢复
+func TestActiveHealthCheck(t *testing.T) {
+	var down int32 = 1
+	peer := flakyPeer(&down)
+	defer peer.Close()
+
+	self := "http://self.invalid"
+	p, _ := NewHTTPPoolOpts(self, &HTTPPoolOptions{
+		HealthCheckInterval: 10 * time.Millisecond,
+		FailureThreshold:    2,
+		OpenTimeout:         30 * time.Millisecond,
+	})
+	defer p.Stop()
+	p.Set(self, peer.URL)
+	h := p.health[peer.URL]
+
+	waitFor := func(healthy bool) {
+		deadline := time.Now().Add(time.Second)
+		for h.healthy() != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("peer healthy should become %v", healthy)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(false)
+	atomic.StoreInt32(&down, 0)
+	waitFor(true)
+}
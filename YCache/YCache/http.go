@@ -7,41 +7,173 @@
 package YCache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"seven-days-projects/YCache/YCache/consistenthash"
 	"seven-days-projects/YCache/YCache/ycachepb"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	defaultBasePath = "/_cache/"
 	defaultReplicas = 50
+
+	// expireHeader 304响应中value的过期时间，304响应没有响应体，只能放在响应头中
+	expireHeader = "X-YCache-Expire"
+
+	// notFoundHeader 404响应中表示key在数据源中不存在，和group不存在的404区分开
+	notFoundHeader = "X-YCache-Not-Found"
+
+	// defaultFallbackLease 等待备用节点加载数据的最长时间，超时之后从本地加载
+	defaultFallbackLease = 3 * time.Second
 )
 
 
+
 // HTTPPool 存放当前节点运行的socket信息
 type HTTPPool struct {
 	self     string
 	basePath string
+	opts     HTTPPoolOptions
 
 	// 下面是新增成员变量，用于客户端实现
 	mu sync.Mutex                      // 添加互斥锁
 	peers *consistenthash.Map          // 添加一致性hash算法实例
 	httpGetters map[string]*httpGetter // 映射真实的cache实例信息与HTTP客户端的对应关系
+	health map[string]*peerHealth      // 每个节点的健康状态
+
+	members  []string        // Set设置的所有节点
+	departed map[string]bool // 宣布离开的节点，不在hash环中
+	draining int32           // 当前节点正在下线，健康检查返回503
+	bus      *PeerBus        // 失效事件总线，为nil表示不处理失效事件的请求
+
+	stop     chan struct{} // 关闭之后停止主动健康检查
+	stopOnce sync.Once
+
+	budget  *retryBudget   // 重试预算
+	latency *latencyWindow // 请求延迟统计
+
+	client         *http.Client // 请求其他节点的客户端
+	fallbackClient *http.Client // 请求备用节点的客户端，超时时间就是租约的时长
+}
+
+// HTTPPoolOptions HTTPPool的配置，零值使用默认配置
+type HTTPPoolOptions struct {
+	// BasePath 节点间通信的路由前缀，默认是 /_cache/
+	BasePath string
+
+	// Replicas 一致性hash中每个节点的虚拟节点个数，默认是50
+	Replicas int
+
+	// HealthCheckInterval 主动探测其他节点 /healthz 的间隔，0表示只根据请求结果被动统计
+	HealthCheckInterval time.Duration
+
+	// FailureThreshold 连续失败多少次之后熔断该节点，默认是3
+	FailureThreshold int
+
+	// OpenTimeout 熔断之后多久进入半开状态重新探测，默认是5秒
+	OpenTimeout time.Duration
+
+	// Retry 请求其他节点失败时的重试策略，nil表示不重试
+	Retry *RetryPolicy
+
+	// Hedge 开启对冲请求，所属节点超过HedgeDelay还没有返回，就向所属节点再发送一次请求，使用先返回的结果
+	Hedge bool
+
+	// HedgeDelay 发送对冲请求之前等待的时间，0表示使用最近请求延迟的p95
+	HedgeDelay time.Duration
+
+	// CertFile、KeyFile 当前节点的证书和私钥，既作为服务端证书，也作为请求其他节点时的客户端证书
+	CertFile string
+	KeyFile  string
+
+	// CAFile 用于校验其他节点证书的CA，设置之后服务端要求客户端提供证书，也就是双向TLS
+	CAFile string
+
+	// Secret 节点间共享的密钥，设置之后每个请求都使用HMAC签名，服务端拒绝没有签名或者签名错误的请求
+	Secret string
+
+	// DisableCompression 关闭响应压缩，默认根据Accept-Encoding协商压缩算法
+	DisableCompression bool
+
+	// CompressThreshold 响应体达到这个大小才压缩，默认是1024字节
+	CompressThreshold int
+
+	// StreamThreshold value达到这个大小的时候使用流式响应，默认是1MB
+	StreamThreshold int
+
+	// MaxResponseBytes 从其他节点接收的响应体的最大长度，防止异常节点返回超大的响应，默认是64MB
+	MaxResponseBytes int64
 }
 
 // NewHTTPPool 构造函数
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
+	// 默认配置不需要加载证书，不会返回错误
+	p, _ := NewHTTPPoolOpts(self, nil)
+	return p
+}
+
+// NewHTTPPoolOpts 使用自定义配置的构造函数，opts为nil的时候使用默认配置，证书加载失败的时候返回错误
+func NewHTTPPoolOpts(self string, opts *HTTPPoolOptions) (*HTTPPool, error) {
+	p := &HTTPPool{
 		self:     self,
 		basePath: defaultBasePath,
+		stop:     make(chan struct{}),
+		latency:  newLatencyWindow(defaultLatencyWindow),
+	}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.BasePath != "" {
+		p.basePath = p.opts.BasePath
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.FailureThreshold == 0 {
+		p.opts.FailureThreshold = defaultFailureThreshold
+	}
+	if p.opts.OpenTimeout == 0 {
+		p.opts.OpenTimeout = defaultOpenTimeout
+	}
+	if p.opts.CompressThreshold == 0 {
+		p.opts.CompressThreshold = defaultCompressThreshold
 	}
+	if p.opts.StreamThreshold == 0 {
+		p.opts.StreamThreshold = defaultStreamThreshold
+	}
+	if p.opts.MaxResponseBytes == 0 {
+		p.opts.MaxResponseBytes = defaultMaxResponseBytes
+	}
+	if p.opts.Retry != nil {
+		p.budget = newRetryBudget(p.opts.Retry.BudgetRatio, p.opts.Retry.MinRetries)
+	}
+	// 所有请求其他节点的客户端共用一个Transport，开启TLS的时候带上客户端证书
+	transport := http.DefaultTransport
+	if p.opts.CertFile != "" || p.opts.CAFile != "" {
+		tlsConfig, err := p.clientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		transport = t
+	}
+	p.client = &http.Client{Transport: transport}
+	p.fallbackClient = &http.Client{Transport: transport, Timeout: defaultFallbackLease}
+	if p.opts.HealthCheckInterval > 0 {
+		go p.healthCheckLoop(p.opts.HealthCheckInterval)
+	}
+	return p, nil
 }
 
 // Log 封装请求日志输出，当有请求进入到server，在ServeHTTP方法中会被调用
@@ -51,13 +183,46 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 
 // ServeHTTP http的handler, 约定的访问路径为/<basepath>/<groupname>/<key>，实现Handler接口的ServeHTTP方法
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 其他节点的健康检查
+	if r.URL.Path == defaultHealthPath {
+		if p.Draining() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		healthHandler(w, r)
+		return
+	}
 	// 验证路由前缀是否以/api开头/
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
+	// 开启了签名校验，拒绝没有签名或者签名错误的请求
+	if p.opts.Secret != "" {
+		if err := verifyRequest(r, p.opts.Secret); err != nil {
+			p.Log("reject %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
 	// 打印日志，包括请求的方法和路径，例如 GET /api/scores/Tom
 	p.Log("%s %s", r.Method, r.URL.Path)
 
+	// 其他节点宣布离开或者重新加入
+	if strings.HasPrefix(r.URL.Path, p.basePath+membershipPath) {
+		p.membershipHandler(w, r)
+		return
+	}
+	// 其他节点按标签失效
+	if r.URL.Path == p.basePath+tagsPath {
+		p.tagsHandler(w, r)
+		return
+	}
+	// 其他节点发布失效事件，或者拉取当前节点最近的事件
+	if r.URL.Path == p.basePath+eventsPath {
+		p.eventsHandler(w, r)
+		return
+	}
+
 	// 获取basePath的字符串长度，也就是字符数，/api/字符数是5
 	basePathLengh := len(p.basePath)
 	// 获取请求路径中去掉/api/的路径， 就是scores/Tom
@@ -81,8 +246,30 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// cache中获取key
-	view, err := group.Get(key)
+	// cache中获取key，如果是备用节点的请求，不再请求所属节点，直接从本地加载
+	// 允许返回过期数据的请求，优先返回cache中还没有被淘汰的value
+	var (
+		view *ByteView
+		err  error
+		ok   bool
+	)
+	query := r.URL.Query()
+	if query.Get("allow_stale") == "1" {
+		view, ok = group.getStale(key)
+	}
+	if !ok {
+		if query.Get("fallback") == "1" {
+			view, err = group.getFallback(key)
+		} else {
+			view, err = group.Get(key)
+		}
+	}
+	// key不存在返回404，请求方不会再请求备用节点或者从本地加载
+	if errors.Is(err, ErrNotFound) {
+		w.Header().Set(notFoundHeader, "1")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -91,13 +278,53 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//// 返回缓存数据，application/octet-stream是二级制流
 	//w.Header().Set("Content-Type", "application/octet-stream")
 	//w.Write(view.ByteSlice())
-	// 通过 proto.Marshal函数对响应的数据进行序列化
-	body, err := proto.Marshal(&ycachepb.Response{Value: view.ByteSlice()})
+	// 除了value之外，返回过期时间、版本和当前节点的QPS，接收方可以据此设置本地的过期时间
+	meta := &ycachepb.Response{MinuteQps: group.MinuteQPS(), Version: view.Version()}
+	// Loader返回的缓存选项一起返回，请求方据此决定是否保存副本
+	if o := view.o; o != nil {
+		meta.NoCache, meta.Tags, meta.Priority, meta.Cost = o.NoCache, o.Tags, int32(o.Priority), o.Cost
+		meta.ContentType, meta.Flags = o.ContentType, o.Flags
+	}
+	if e := view.Expire(); !e.IsZero() {
+		meta.Expire = e.UnixNano()
+	}
+	// 客户端的副本和当前版本一致，只返回304，不再传输value
+	if view.Version() != "" {
+		etag := strconv.Quote(view.Version())
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			if meta.Expire != 0 {
+				w.Header().Set(expireHeader, strconv.FormatInt(meta.Expire, 10))
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	// 大value使用流式响应，直接从ByteView分块写入，不再序列化一份完整的响应体，流式响应不压缩
+	if data := view.data(); len(data) >= p.opts.StreamThreshold {
+		if err := writeStreamed(w, data, meta); err != nil {
+			p.Log("stream %s failed: %v", r.URL.Path, err)
+		}
+		return
+	}
+	// 通过 proto.Marshal函数对响应的数据进行序列化，序列化只读取value，直接使用cache中的数据，不再拷贝
+	meta.Value = view.data()
+	body, err := proto.Marshal(meta)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
+	// 客户端支持压缩，并且响应体足够大的时候压缩之后再返回
+	if !p.opts.DisableCompression && len(body) >= p.opts.CompressThreshold {
+		if c := negotiate(r.Header.Get("Accept-Encoding")); c != nil {
+			if compressed, err := compress(c, body); err == nil && len(compressed) < len(body) {
+				w.Header().Set("Content-Encoding", c.name)
+				body = compressed
+			}
+		}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
 	w.Write(body)
 }
 
@@ -107,11 +334,30 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // 表示HTTP的请求信息，例如：例如 http://locahost:8080/api/，
 type httpGetter struct {
 	baseURL string
+	health  *peerHealth // 节点的健康状态，请求结果会被计入熔断器
+
+	retry   *RetryPolicy   // 重试策略，为nil表示不重试
+	budget  *retryBudget   // 所有节点共享的重试预算
+	latency *latencyWindow // 所有节点共享的请求延迟统计，用于计算对冲请求的延迟
+
+	client         *http.Client // 为nil的时候使用http.DefaultClient
+	fallbackClient *http.Client // 请求备用节点的客户端
+	secret         string       // 请求签名的密钥，为空表示不签名
+
+	disableCompression bool  // 不接受压缩的响应
+	maxResponseBytes   int64 // 响应体的最大长度，0表示使用默认值
+
+	minuteQPS atomic.Value // 节点最近一次上报的QPS
 }
 
 // Get 实现PeerGetter接口的方法，构建HTTP客户端
 // Get方法的实现也要改
 func (h *httpGetter) Get(in *ycachepb.Request, out *ycachepb.Response) error {
+	return h.getWithRetry(context.Background(), in, out)
+}
+
+// get 发送一次HTTP请求，retryable表示失败之后是否可以重试
+func (h *httpGetter) get(ctx context.Context, in *ycachepb.Request, out *ycachepb.Response) (retryable bool, err error) {
 
 	// 拼凑url：http://locahost:8080/api/scores/Tom
 	u := fmt.Sprintf(
@@ -120,28 +366,114 @@ func (h *httpGetter) Get(in *ycachepb.Request, out *ycachepb.Response) error {
 		url.QueryEscape(in.GetGroup()), // 将url的字符串转移，类似于url路径的编码
 		url.QueryEscape(in.GetKey()),
 	)
-	// HTTP客户端请求cache的IP地址
-	res, err := http.Get(u)
+	// HTTP客户端请求cache的IP地址，请求备用节点的时候带上fallback参数，请求的提示信息放在query参数中
+	client := h.client
+	query := url.Values{}
+	if in.GetFallback() {
+		query.Set("fallback", "1")
+		client = h.fallbackClient
+	}
+	if in.GetAllowStale() {
+		query.Set("allow_stale", "1")
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	// 自己设置Accept-Encoding之后，Transport不会再自动解压，由下面统一处理
+	if h.disableCompression {
+		req.Header.Set("Accept-Encoding", "identity")
+	} else {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+	}
+	// 本地有副本，发起条件请求
+	if in.GetVersion() != "" {
+		req.Header.Set("If-None-Match", strconv.Quote(in.GetVersion()))
+	}
+	if h.secret != "" {
+		signRequest(req, h.secret)
+	}
+	start := time.Now()
+	res, err := client.Do(req)
+	// 只有网络错误才计入熔断器，key不存在等业务错误说明节点本身是正常的，调用方主动取消的请求也不计入
 	if err != nil {
-		return err
+		if ctx.Err() != nil {
+			return false, err
+		}
+		if h.health != nil {
+			h.health.failure()
+		}
+		return true, err
+	}
+	if h.health != nil {
+		h.health.success()
 	}
 	defer res.Body.Close()
 
+	// value没有变化，只有版本和过期时间
+	if res.StatusCode == http.StatusNotModified {
+		out.NotModified = true
+		out.Version, _ = strconv.Unquote(res.Header.Get("ETag"))
+		out.Expire, _ = strconv.ParseInt(res.Header.Get(expireHeader), 10, 64)
+		return false, nil
+	}
+	// 所属节点确认key不存在，重试也不会成功
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) == "1" {
+		return false, fmt.Errorf("%s/%s: %w", in.GetGroup(), in.GetKey(), ErrNotFound)
+	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		// 网关错误和服务不可用可以重试，其他错误重试也不会成功
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			retryable = true
+		}
+		return retryable, fmt.Errorf("server returned: %v", res.Status)
 	}
 	// 获取请求数据，转换为[]byte类型，此时请求数据一定是记录的值
-	bytes, err := ioutil.ReadAll(res.Body)
+	// 超过长度上限的响应重试也不会变小，只有读取中断等错误才重试
+	bytes, err := readBody(res, h.maxResponseBytes)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return !errors.Is(err, errResponseTooLarge), fmt.Errorf("reading response body: %w", err)
 	}
+	// 流式响应直接引用响应体，不再拷贝value
+	if res.Header.Get(streamHeader) == "1" {
+		if err = decodeStreamed(bytes, out); err != nil {
+			return false, fmt.Errorf("decoding streamed response body: %v", err)
+		}
+	} else {
+		// 响应体被压缩过，先解压
+		if encoding := res.Header.Get("Content-Encoding"); encoding != "" {
+			c := getCompressor(encoding)
+			if c == nil {
+				return false, fmt.Errorf("unsupported content encoding: %s", encoding)
+			}
+			limit := h.maxResponseBytes
+			if limit <= 0 {
+				limit = defaultMaxResponseBytes
+			}
+			if bytes, err = decompress(c, bytes, limit); err != nil {
+				return false, fmt.Errorf("decompressing response body: %v", err)
+			}
+		}
 
-	// 将bytes基于protobuf解码，解码的数据写入到out
-	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+		// 将bytes基于protobuf解码，解码的数据写入到out
+		if err = proto.Unmarshal(bytes, out); err != nil {
+			return false, fmt.Errorf("decoding response body: %v", err)
+		}
+	}
+	if h.latency != nil {
+		h.latency.add(time.Since(start))
 	}
+	// 记录节点上报的QPS，旧版本的节点不会上报
+	h.minuteQPS.Store(out.GetMinuteQps())
 	//return bytes, nil
-	return nil
+	return false, nil
 }
 
 // 验证httpGetter是否实现了PeerGetter接口
@@ -150,20 +482,55 @@ var _ PeerGetter = &httpGetter{}
 //var _ PeerGetter = (*httpGetter)(nil)
 
 // Set 实现了基于cache节点信息创建节点与HTTP请求信息的对应关系
+// 重新设置节点列表之后，之前宣布离开的节点也会重新加入
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.members = append([]string(nil), peers...)
+	p.departed = nil
+	p.rebuild()
+}
+
+// rebuild 根据members中没有离开的节点重建hash环，调用方需要持有p.mu
+func (p *HTTPPool) rebuild() {
+	var peers []string
+	for _, peer := range p.members {
+		if !p.departed[peer] {
+			peers = append(peers, peer)
+		}
+	}
 	// 获取一致性hash算法实例
-	p.peers = consistenthash.NewMap(defaultReplicas, nil)
+	p.peers = consistenthash.NewMap(p.opts.Replicas, nil)
 	// 添加cache节点信息
 	p.peers.Add(peers...)
 	// 初始化cache节点与URL的对应关系，例如 {"127.0.0.1": "httpClient1", "127.0.0.2": "httpClient2",}
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	// 已经存在的节点保留原来的健康状态
+	health := make(map[string]*peerHealth, len(peers))
 	// 遍历cache节点，创建cache节点与HTTP客户端映射关系，因为httpGetter实现了HTTP客户端+url
 	for _, peer := range peers {
+		h, ok := p.health[peer]
+		if !ok {
+			h = newPeerHealth(p.opts.FailureThreshold, p.opts.OpenTimeout)
+		}
+		health[peer] = h
 		// peer + p.basePath 为 127.0.0.1/api/
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		p.httpGetters[peer] = &httpGetter{
+			baseURL: peer + p.basePath,
+			health:  h,
+			retry:   p.opts.Retry,
+			budget:  p.budget,
+			latency: p.latency,
+
+			client:         p.client,
+			fallbackClient: p.fallbackClient,
+			secret:         p.opts.Secret,
+
+			disableCompression: p.opts.DisableCompression,
+			maxResponseBytes:   p.opts.MaxResponseBytes,
+		}
 	}
+	p.health = health
 }
 
 // PickPeer 实现PeerPicker接口PickPeer方法，根据具体的 key，选择cache节点，返回节点对应的 HTTP 客户端
@@ -171,16 +538,74 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// 基于p.peers一致性hash算法获取节点信息，如果节点不是自己，也不为空，那么获取到HTTP客户端
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("Get data from %s", peer)
-		// 基于cache节点信息获取到客户端
-		return p.httpGetters[peer], true
+	if p.peers == nil {
+		return nil, false
+	}
+	// 基于p.peers一致性hash算法获取节点信息，所属节点处于熔断状态的时候，沿着hash环选择下一个节点
+	nodes := p.peers.GetN(key, len(p.httpGetters))
+	for i, peer := range nodes {
+		// 如果是自己，那么返回nil
+		if peer == p.self {
+			return nil, false
+		}
+		if p.health[peer].allow() {
+			p.Log("Get data from %s", peer)
+			// 基于cache节点信息获取到客户端，开启对冲请求的时候，只对所属节点发送对冲请求
+			if p.opts.Hedge && i == 0 {
+				return &hedgedGetter{getter: p.httpGetters[peer], delay: p.hedgeDelay()}, true
+			}
+			return p.httpGetters[peer], true
+		}
 	}
 
-	// 如果是自己，那么返回nil
+	// 所有节点都不可用，从本地加载
 	return nil, false
 }
 
+// IsOwner 实现OwnerPicker接口，只看hash环，不考虑所属节点是否可用，没有设置节点的时候所有key都属于当前节点
+func (p *HTTPPool) IsOwner(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return true
+	}
+	return p.peers.Get(key) == p.self
+}
+
+// PickFallback 实现FallbackPicker接口，返回hash环上所属节点之后的下一个节点
+func (p *HTTPPool) PickFallback(key string) (PeerGetter, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil, false, false
+	}
+	// 跳过所属节点，以及处于熔断状态的节点
+	nodes := p.peers.GetN(key, len(p.httpGetters))
+	for i := 1; i < len(nodes); i++ {
+		if nodes[i] == p.self {
+			return nil, true, true
+		}
+		if p.health[nodes[i]].healthy() {
+			p.Log("Fallback to %s", nodes[i])
+			return p.httpGetters[nodes[i]], false, true
+		}
+	}
+	return nil, false, false
+}
+
+// PeerQPS 获取节点最近一次上报的每分钟QPS，可以作为路由和热点判断的依据
+func (p *HTTPPool) PeerQPS(peer string) float64 {
+	p.mu.Lock()
+	getter, ok := p.httpGetters[peer]
+	p.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	qps, _ := getter.minuteQPS.Load().(float64)
+	return qps
+}
+
 // 验证HTTPPool实现了PeerPicker接口
-var _ PeerPicker = &HTTPPool{}
\ No newline at end of file
+var _ PeerPicker = &HTTPPool{}
+var _ FallbackPicker = &HTTPPool{}
\ No newline at end of file
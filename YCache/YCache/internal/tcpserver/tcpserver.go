@@ -0,0 +1,241 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package tcpserver 基于TCP的前端服务共用的监听和连接管理，协议相关的处理交给每个连接的handler
+package tcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// initialRead ReadN不按照客户端声明的长度一次性分配的阈值
+	initialRead = 64 << 10
+
+	// DefaultIdleTimeout 连接上等待下一个命令的默认时长，超时之后关闭连接
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultReadTimeout 命令开始之后读完整个命令的默认时长，避免慢速客户端一直占用连接和内存
+	DefaultReadTimeout = 30 * time.Second
+
+	// shutdownPollInterval Shutdown检查连接是否都已经关闭的间隔
+	shutdownPollInterval = 10 * time.Millisecond
+)
+
+// Server 记录所有监听和连接，Close的时候全部关闭
+type Server struct {
+	name    string
+	handler func(conn net.Conn)
+
+	// IdleTimeout 等待下一个命令的最长时间，0表示使用DefaultIdleTimeout
+	IdleTimeout time.Duration
+	// ReadTimeout 读取一个命令的最长时间，0表示使用DefaultReadTimeout
+	ReadTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]bool // 连接 -> 是否正在等待下一个命令
+	closed    bool
+}
+
+// New 创建Server，name用于日志，handler处理一个连接，返回之后连接会被关闭
+func New(name string, handler func(conn net.Conn)) *Server {
+	return &Server{
+		name:      name,
+		handler:   handler,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// ListenAndServe 监听addr并处理请求，阻塞直到出错或者Close
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve 处理ln上的连接，阻塞直到出错或者Close
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln, nil) {
+		ln.Close()
+		return net.ErrClosed
+	}
+	defer s.untrack(ln, nil)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.Closed() {
+				return net.ErrClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return net.ErrClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown 停止接受新连接，关闭空闲的连接，等待正在执行命令的连接处理完当前的命令之后关闭
+// ctx超时之后关闭剩余的连接，返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for s.Connections() > 0 {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有监听和连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// Next 等待连接上的下一个命令，r是连接的bufio.Reader，handler在读取每个命令之前调用
+// 等待命令到达的时间不超过IdleTimeout，命令开始到达之后，读完整个命令的时间不超过ReadTimeout
+// Shutdown之后不再等待新的命令，返回net.ErrClosed
+func (s *Server) Next(conn net.Conn, r *bufio.Reader) error {
+	idle, read := s.IdleTimeout, s.ReadTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	if read <= 0 {
+		read = DefaultReadTimeout
+	}
+	// pipeline中已经缓冲的命令不需要等待
+	if r.Buffered() == 0 {
+		if !s.setIdle(conn, true) {
+			return net.ErrClosed
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(idle)); err != nil {
+			return err
+		}
+		if _, err := r.Peek(1); err != nil {
+			return err
+		}
+		if !s.setIdle(conn, false) {
+			return net.ErrClosed
+		}
+	}
+	return conn.SetReadDeadline(time.Now().Add(read))
+}
+
+// ReadN 读取n个字节，n来自客户端的时候使用，超过64KB的时候随着数据到达逐步扩容，而不是一次性分配
+// 客户端声明了很大的长度却不发送数据，不会占用对应的内存
+func ReadN(r io.Reader, n int) ([]byte, error) {
+	if n <= initialRead {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Closed 判断服务是否已经关闭，handler据此区分读写失败是不是Close导致的
+func (s *Server) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Connections 当前的连接数
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// serveConn 调用handler处理连接，handler panic的时候只关闭这个连接，不影响整个进程
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrack(nil, conn)
+	defer conn.Close()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[YCache] %s connection from %s panicked: %v", s.name, conn.RemoteAddr(), err)
+		}
+	}()
+	s.handler(conn)
+}
+
+// setIdle 记录连接是否正在等待下一个命令，服务已经关闭的时候返回false
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = idle
+	return true
+}
+
+// track 记录监听和连接，服务已经关闭的时候返回false
+func (s *Server) track(ln net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if ln != nil {
+		s.listeners[ln] = struct{}{}
+	}
+	if conn != nil {
+		s.conns[conn] = false
+	}
+	return true
+}
+
+func (s *Server) untrack(ln net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, ln)
+	delete(s.conns, conn)
+}
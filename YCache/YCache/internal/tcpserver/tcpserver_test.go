@@ -0,0 +1,145 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package tcpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestServer handler处理连接，panic只关闭当前连接，Close之后关闭所有连接，Serve返回net.ErrClosed
+func TestServer(t *testing.T) {
+	s := New("echo", func(conn net.Conn) {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "panic\n" {
+			panic("bad request")
+		}
+		conn.Write([]byte(line))
+		io.Copy(io.Discard, conn)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	dial := func(request string) (net.Conn, string) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(request))
+		reply, _ := bufio.NewReader(conn).ReadString('\n')
+		return conn, reply
+	}
+
+	broken, reply := dial("panic\n")
+	if reply != "" {
+		t.Fatalf("panicking handler should close the connection, got %q", reply)
+	}
+	broken.Close()
+	conn, reply := dial("hello\n")
+	if reply != "hello\n" || s.Connections() != 1 {
+		t.Fatalf("server should keep serving after a panic: %q, %d connections", reply, s.Connections())
+	}
+
+	s.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Close should close open connections")
+	}
+	if err := <-served; !errors.Is(err, net.ErrClosed) || !s.Closed() {
+		t.Fatalf("Serve should return net.ErrClosed after Close, got %v", err)
+	}
+}
+
+// TestShutdown Shutdown关闭空闲的连接，等待正在执行的命令完成，超时之后关闭剩余的连接
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	var s *Server
+	s = New("slow", func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for s.Next(conn, r) == nil {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			started <- struct{}{}
+			time.Sleep(100 * time.Millisecond)
+			conn.Write([]byte(line))
+		}
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	idle, busy := dial(), dial()
+	defer idle.Close()
+	defer busy.Close()
+	busy.Write([]byte("hello\n"))
+	<-started
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := bufio.NewReader(busy).ReadString('\n'); reply != "hello\n" {
+		t.Fatalf("running command should finish before shutdown, got %q", reply)
+	}
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("idle connection should be closed")
+	}
+
+	// 超时之后直接关闭
+	s = New("stuck", func(conn net.Conn) {
+		started <- struct{}{}
+		io.Copy(io.Discard, conn)
+	})
+	ln, _ = net.Listen("tcp", "127.0.0.1:0")
+	go s.Serve(ln)
+	stuck := dial()
+	defer stuck.Close()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown should return the context error, got %v", err)
+	}
+	if !waitClosed(s) {
+		t.Fatalf("Shutdown should close connections after the deadline")
+	}
+}
+
+// waitClosed 等待所有连接的handler退出
+func waitClosed(s *Server) bool {
+	deadline := time.Now().Add(time.Second)
+	for s.Connections() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import "sync"
+
+// memoryShare 单个cacheInstance在全局预算中的份额
+type memoryShare struct {
+	minBytes int64 // 全局淘汰时保留的最小内存，低于该值的cacheInstance不会被跨group淘汰
+	maxBytes int64 // 最多能占用的内存，0表示不限制
+}
+
+// MemoryLimiter 进程级内存预算，多个Group的cacheInstance共享同一个上限
+// 当总内存超过上限时，在所有cacheInstance中淘汰最久未被访问的记录
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	maxBytes int64                           // 所有cacheInstance加起来的最大内存
+	shares   map[*cacheInstance]*memoryShare // cacheInstance与份额的对应关系
+}
+
+// NewMemoryLimiter MemoryLimiter构造函数
+func NewMemoryLimiter(maxBytes int64) *MemoryLimiter {
+	return &MemoryLimiter{
+		maxBytes: maxBytes,
+		shares:   make(map[*cacheInstance]*memoryShare),
+	}
+}
+
+// register 将cacheInstance加入预算
+func (l *MemoryLimiter) register(c *cacheInstance, minBytes, maxBytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shares[c] = &memoryShare{minBytes: minBytes, maxBytes: maxBytes}
+}
+
+// Bytes 获取所有cacheInstance当前占用的内存之和
+func (l *MemoryLimiter) Bytes() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total int64
+	for c := range l.shares {
+		total += c.bytes()
+	}
+	return total
+}
+
+// enforce 淘汰记录直到每个cacheInstance不超过自己的最大份额，并且总内存不超过全局预算
+// 锁的顺序固定为先MemoryLimiter再cacheInstance
+func (l *MemoryLimiter) enforce() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		var (
+			total      int64
+			overMax    *cacheInstance // 超过最大份额的cacheInstance，优先淘汰
+			victim     *cacheInstance // 全局最久未被访问记录所在的cacheInstance
+			victimTick uint64
+		)
+		for c, s := range l.shares {
+			n := c.bytes()
+			total += n
+			if s.maxBytes > 0 && n > s.maxBytes {
+				overMax = c
+			}
+			// 已经处于最小份额的cacheInstance不参与跨group淘汰
+			if n <= s.minBytes {
+				continue
+			}
+			if tick, ok := c.oldestTick(); ok && (victim == nil || tick < victimTick) {
+				victim, victimTick = c, tick
+			}
+		}
+		if overMax != nil {
+			overMax.removeOldest()
+			continue
+		}
+		if l.maxBytes == 0 || total <= l.maxBytes || victim == nil {
+			return
+		}
+		victim.removeOldest()
+	}
+}
+
+// SetMemoryLimiter 将Group的缓存纳入进程级内存预算
+// minBytes是全局预算紧张时为该Group保留的内存，maxBytes是该Group最多能占用的内存，0表示不限制
+func (g *Group) SetMemoryLimiter(l *MemoryLimiter, minBytes, maxBytes int64) {
+	l.register(&g.mainCache, minBytes, maxBytes)
+	g.mainCache.mu.Lock()
+	g.mainCache.limiter = l
+	g.mainCache.mu.Unlock()
+	l.enforce()
+}
@@ -0,0 +1,72 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import "testing"
+
+// TestMemoryLimiter 测试多个group共享全局内存预算时，淘汰的是全局最久未被访问的记录
+func TestMemoryLimiter(t *testing.T) {
+	echo := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("1234567890"), nil
+	})
+	limiter := NewMemoryLimiter(60)
+	a := NewGroup("limiter-a", 0, echo)
+	b := NewGroup("limiter-b", 0, echo)
+	a.SetMemoryLimiter(limiter, 0, 0)
+	b.SetMemoryLimiter(limiter, 0, 0)
+
+	// 每条记录占用 2 + 10 = 12 字节，5条记录刚好60字节
+	for _, k := range []string{"a1", "a2", "a3"} {
+		a.Get(k)
+	}
+	for _, k := range []string{"b1", "b2"} {
+		b.Get(k)
+	}
+	// 再次访问a1，那么全局最久未被访问的是a2
+	a.Get("a1")
+	b.Get("b3")
+
+	if limiter.Bytes() > 60 {
+		t.Fatalf("limiter exceeded budget: %d", limiter.Bytes())
+	}
+	if _, ok := a.mainCache.GetValue("a2"); ok {
+		t.Fatalf("a2 should be evicted")
+	}
+	if _, ok := a.mainCache.GetValue("a1"); !ok {
+		t.Fatalf("a1 should be kept")
+	}
+}
+
+// TestMemoryLimiterShares 测试最小份额和最大份额
+func TestMemoryLimiterShares(t *testing.T) {
+	echo := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("1234567890"), nil
+	})
+	limiter := NewMemoryLimiter(48)
+	a := NewGroup("share-a", 0, echo)
+	b := NewGroup("share-b", 0, echo)
+	a.SetMemoryLimiter(limiter, 24, 0)
+	b.SetMemoryLimiter(limiter, 0, 24)
+
+	a.Get("a1")
+	a.Get("a2")
+	for _, k := range []string{"b1", "b2", "b3"} {
+		b.Get(k)
+	}
+	// b超过最大份额，只能保留2条
+	if n := b.mainCache.bytes(); n > 24 {
+		t.Fatalf("group b exceeded max share: %d", n)
+	}
+	a.Get("a3")
+	// a1最久未被访问，但a只剩最小份额以上的部分可以淘汰，总量仍然不能超过预算
+	if limiter.Bytes() > 48 {
+		t.Fatalf("limiter exceeded budget: %d", limiter.Bytes())
+	}
+	if n := a.mainCache.bytes(); n < 24 {
+		t.Fatalf("group a dropped below min share: %d", n)
+	}
+}
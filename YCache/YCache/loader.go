@@ -0,0 +1,75 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import "time"
+
+// LoadOptions Loader加载value时附带的缓存选项，零值和普通的Getter行为一致
+type LoadOptions struct {
+	TTL      time.Duration // value的有效期，0表示使用Group的TTL
+	NoCache  bool          // 只返回给调用方，不写入cache，其他节点也不会保存副本
+	Priority int           // 淘汰优先级，记录被淘汰之前可以多留在cache中Priority轮，最多lru.MaxPriority轮，见lru.Prioritizer
+	Cost     int64         // 计入cache的内存，0表示使用value的长度，可以用来体现value在cache之外占用的资源
+	Tags     []string      // 标签，用于按标签批量失效
+
+	// ContentType value的MIME类型，随响应传给其他节点，HTTP API据此设置Content-Type
+	ContentType string
+
+	// Flags 客户端为value设置的32位标志，YCache不解释，memcached协议的get原样返回
+	Flags uint32
+}
+
+// Loader 扩展的Getter，除了value之外还可以返回每个key的缓存选项
+// Group的Getter同时实现了Loader接口的时候，cache miss时调用Load而不是Get
+// 和Getter不同，Load返回的数组直接交给cache，不会再拷贝，Loader之后不能再修改
+type Loader interface {
+	Load(key string) ([]byte, LoadOptions, error)
+}
+
+// LoaderFunc 函数类型，同时实现Loader和Getter接口，可以直接传给NewGroup
+type LoaderFunc func(key string) ([]byte, LoadOptions, error)
+
+// Load 实现Loader接口的Load方法
+func (f LoaderFunc) Load(key string) ([]byte, LoadOptions, error) {
+	return f(key)
+}
+
+// Get 实现Getter接口的Get方法，忽略缓存选项
+func (f LoaderFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// loadValue 调用Getter加载value，Getter实现了Loader接口的时候按照LoadOptions设置ByteView
+func (g *Group) loadValue(key string) (*ByteView, error) {
+	var (
+		value *ByteView
+		ttl   = g.ttl
+	)
+	if loader, ok := g.getter.(Loader); ok {
+		bytes, opts, err := loader.Load(key)
+		if err != nil {
+			return nil, err
+		}
+		// Loader返回的数组直接交给cache
+		value = &ByteView{b: bytes, o: &opts}
+		if opts.TTL > 0 {
+			ttl = opts.TTL
+		}
+	} else {
+		bytes, err := g.getter.Get(key) // 执行用户传递的回调函数
+		if err != nil {
+			return nil, err
+		}
+		// Getter返回的数组可能还被Getter自己使用，拷贝一份
+		value = &ByteView{b: cloneBytes(bytes)}
+	}
+	if ttl > 0 {
+		value.e = time.Now().Add(ttl)
+	}
+	return value, nil
+}
@@ -0,0 +1,95 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestLoaderOptions Loader返回的TTL、不缓存和标签等选项
+func TestLoaderOptions(t *testing.T) {
+	loads := map[string]int{}
+	g := NewGroup("loader", 2<<10, LoaderFunc(func(key string) ([]byte, LoadOptions, error) {
+		loads[key]++
+		switch key {
+		case "short":
+			return []byte("1"), LoadOptions{TTL: time.Minute, Tags: []string{"user:1"}, Priority: 2}, nil
+		case "volatile":
+			return []byte("2"), LoadOptions{NoCache: true}, nil
+		}
+		return []byte("3"), LoadOptions{}, nil
+	}))
+	g.SetTTL(time.Hour)
+
+	v, err := g.Get("short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(v.Expire()); d <= 0 || d > time.Minute {
+		t.Errorf("TTL from loader not honored: expires in %v", d)
+	}
+	if !reflect.DeepEqual(v.Tags(), []string{"user:1"}) || v.Priority() != 2 {
+		t.Errorf("options not stored: tags %v, priority %d", v.Tags(), v.Priority())
+	}
+	if v, _ := g.Get("other"); time.Until(v.Expire()) <= time.Minute {
+		t.Errorf("group TTL should be used without loader TTL")
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("volatile"); err != nil || v.String() != "2" {
+			t.Fatalf("Get(volatile) = %v, %v", v, err)
+		}
+	}
+	g.Get("short")
+	if loads["volatile"] != 2 || loads["short"] != 1 {
+		t.Fatalf("loads = %v", loads)
+	}
+
+	// GetterFunc仍然可以使用，LoaderFunc也可以当作Getter
+	var getter Getter = LoaderFunc(func(key string) ([]byte, LoadOptions, error) {
+		return []byte(key), LoadOptions{NoCache: true}, nil
+	})
+	if b, err := getter.Get("Tom"); err != nil || string(b) != "Tom" {
+		t.Fatalf("LoaderFunc.Get = %q, %v", b, err)
+	}
+}
+
+// TestLoaderOptionsFromPeer 缓存选项随响应传给其他节点，不缓存的value不保存到hotCache
+func TestLoaderOptionsFromPeer(t *testing.T) {
+	NewGroup("loader-peer", 2<<10, LoaderFunc(func(key string) ([]byte, LoadOptions, error) {
+		return []byte(key), LoadOptions{NoCache: key == "volatile", Tags: []string{"t:" + key}, Priority: 1, Cost: 100, ContentType: "text/plain", Flags: 9}, nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	g := NewGroup("loader-peer-client", 0, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key should be loaded from peer")
+		return nil, nil
+	}))
+	g.RegisterPeers(&rewritePeer{group: "loader-peer", getter: &httpGetter{baseURL: srv.URL + defaultBasePath}})
+	g.SetHotCache(2<<10, time.Hour)
+
+	v, err := g.Get("Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Tags(), []string{"t:Tom"}) || v.Priority() != 1 || v.Cost() != 100 || v.ContentType() != "text/plain" || v.Flags() != 9 {
+		t.Fatalf("options not passed from peer: tags %v, priority %d, cost %d, content type %q, flags %d", v.Tags(), v.Priority(), v.Cost(), v.ContentType(), v.Flags())
+	}
+	if _, ok := g.hotCache.peek("Tom"); !ok {
+		t.Fatalf("value should be kept in hotCache")
+	}
+	if _, err := g.Get("volatile"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.hotCache.peek("volatile"); ok {
+		t.Fatalf("no-cache value should not be kept in hotCache")
+	}
+}
@@ -6,12 +6,27 @@
 
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// EntryOverhead 估算的每条记录在key和value之外额外占用的内存，单位是字节
+// 包括list.Element(48)、entry(48)，以及map中key的string头、指针和空闲槽位的均摊(约40)
+const EntryOverhead = 136
+
+// MaxPriority 豁免次数的上限，Priority超过上限按上限计算，避免一次淘汰把同一条记录放回队首太多次
+const MaxPriority = 8
+
+// clock 进程内所有Cache共享的逻辑时钟，每次访问记录都会递增，用于跨Cache比较记录的新旧程度
+var clock uint64
 
 // 双向链表节点数据类型
 type entry struct {
 	key   string // 这里的key于map中的key是同一个key
 	value Value
+	tick  uint64 // 最近一次访问时的逻辑时钟
+	spare int    // 剩余的豁免次数，淘汰到这条记录时如果大于0，减1之后放回队首
 }
 
 // Value 类型需要实现Len方法，返回链表节点entry的大小
@@ -19,12 +34,32 @@ type Value interface {
 	Len() int
 }
 
+// Coster Value可以选择实现的接口，Cost返回计入cache的内存，代替Len
+// 例如value在cache之外还持有其他资源的时候，可以按实际占用计算
+type Coster interface {
+	Cost() int64
+}
+
+// Prioritizer Value可以选择实现的接口，Priority大于0的记录被淘汰之前可以多留在cache中Priority轮，最多MaxPriority轮
+// 每轮是指淘汰到这条记录时把它放回队首，被访问之后重新获得全部的豁免次数
+type Prioritizer interface {
+	Priority() int
+}
+
+// Tagger Value可以选择实现的接口，Cache为Tags建立标签到key的索引，用于按标签批量删除记录
+// 同一个key的Tags在写入之后不能再修改，否则索引会和记录不一致
+type Tagger interface {
+	Tags() []string
+}
+
 // Cache 缓存节点数据结构
 type Cache struct {
 	maxBytes int64                          // 节点最大内存
 	nbytes   int64                          // 节点当前内存
 	ll       *list.List                     // 双向链表
 	cache    map[string]*list.Element       // map
+	overhead int64                          // 每条记录额外计入的内存，为0时只统计key和value的长度
+	tags     map[string]map[string]struct{} // 标签到key的索引，只包含实现了Tagger接口的value
 	OnEvicted func(key string, value Value) //当链表数据被删除的回调函数
 }
 
@@ -44,44 +79,196 @@ func (c *Cache) GetValue(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok { // 查询map中是否有对应的key，如果存在获取value，也就是链表的元素
 		c.ll.MoveToFront(ele)    // 将当前元素移动到队首，为lru算法做铺垫，那么队尾的就是最近最少使用的节点，优先删除
 		kv := ele.Value.(*entry) // 获取链表节点数据
+		kv.tick = atomic.AddUint64(&clock, 1)
+		kv.spare = priority(kv.value)
 		return kv.value, true
 	}
 	return
 }
 
+// Remove 删除指定的key
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
 // RemoveOldest 获取到队尾节点，从链表中删除，这也是
+// 队尾节点还有豁免次数的时候放回队首，继续检查下一个队尾节点
 func (c *Cache) RemoveOldest() {
-	ele := c.ll.Back() // 获取链表最后一个节点
-	if ele != nil {
-		c.ll.Remove(ele)                                       // 删除链表最后一个节点
-		kv := ele.Value.(*entry)                               // 获取节点的key
-		delete(c.cache, kv.key)                                // 删除map中的key
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) // 重新计算链表的内存大小
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value) // 执行回调函数
+	for {
+		ele := c.ll.Back() // 获取链表最后一个节点
+		if ele == nil {
+			return
 		}
+		kv := ele.Value.(*entry)
+		if kv.spare <= 0 {
+			c.removeElement(ele)
+			return
+		}
+		kv.spare--
+		kv.tick = atomic.AddUint64(&clock, 1)
+		c.ll.MoveToFront(ele)
+	}
+}
+
+// removeElement 从链表和map中删除节点
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)                          // 删除链表节点
+	kv := ele.Value.(*entry)                  // 获取节点的key
+	delete(c.cache, kv.key)                   // 删除map中的key
+	c.unindex(kv.key, kv.value)               // 删除标签索引
+	c.nbytes -= c.entrySize(kv.key, kv.value) // 重新计算链表的内存大小
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value) // 执行回调函数
 	}
 }
 
 // Add 新增/修改
 func (c *Cache) Add(key string, value Value) {
+	// 单条记录就超过了cache最大内存，插入后会把其他记录全部淘汰，最后把自己也淘汰掉，因此直接拒绝
+	// 如果key已经存在，旧的value也要删除，避免读到过期的数据
+	if c.maxBytes != 0 && c.entrySize(key, value) > c.maxBytes {
+		c.Remove(key)
+		return
+	}
 	if ele, ok := c.cache[key]; ok { // 如果key在map中存在，表示更新cache节点数据
 		c.ll.MoveToFront(ele)                                  // 移动当前节点链表队首
 		kv := ele.Value.(*entry)                               // 获取当前节点的entry
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len()) // 重新计算链表的内存大小
+		c.nbytes += cost(value) - cost(kv.value)               // 重新计算链表的内存大小
+		c.unindex(key, kv.value)
+		c.index(key, value)
 		kv.value = value
+		kv.tick = atomic.AddUint64(&clock, 1)
+		kv.spare = priority(value)
 	} else { // 如果key在map中不存在，表示添加节点数据
-		ele = c.ll.PushFront(&entry{key, value})         // 在链表头部插入新节点
+		ele = c.ll.PushFront(&entry{key, value, atomic.AddUint64(&clock, 1), priority(value)}) // 在链表头部插入新节点
 		c.cache[key] = ele                               // 添加map映射
-		c.nbytes += int64(len(key)) + int64(value.Len()) // 重新计算链表的内存大小
+		c.index(key, value)                              // 添加标签索引
+		c.nbytes += c.entrySize(key, value)              // 重新计算链表的内存大小
 	}
 	// 添加节点的时候需要判断是否超过了cache最大内存，如果超过，需要删除最近最少使用的节点
+	c.evict()
+}
+
+// RemoveTag 删除所有带有tag的记录，每条记录都会执行OnEvicted回调，返回删除的记录数
+func (c *Cache) RemoveTag(tag string) int {
+	keys := c.KeysByTag(tag)
+	for _, key := range keys {
+		c.Remove(key)
+	}
+	return len(keys)
+}
+
+// KeysByTag 获取所有带有tag的key，顺序不固定
+func (c *Cache) KeysByTag(tag string) []string {
+	index := c.tags[tag]
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// index 把key加入value所有标签的索引
+func (c *Cache) index(key string, value Value) {
+	v, ok := value.(Tagger)
+	if !ok {
+		return
+	}
+	for _, tag := range v.Tags() {
+		if c.tags == nil {
+			c.tags = make(map[string]map[string]struct{})
+		}
+		keys := c.tags[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// unindex 把key从value所有标签的索引中删除，没有key的标签一起删除
+func (c *Cache) unindex(key string, value Value) {
+	v, ok := value.(Tagger)
+	if !ok {
+		return
+	}
+	for _, tag := range v.Tags() {
+		if keys := c.tags[tag]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+}
+
+// evict 如果超过了cache最大内存，删除最近最少使用的节点
+func (c *Cache) evict() {
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
 		c.RemoveOldest()
 	}
 }
 
+// entrySize 计算一条记录占用的内存
+func (c *Cache) entrySize(key string, value Value) int64 {
+	return int64(len(key)) + cost(value) + c.overhead
+}
+
+// cost 计算value计入cache的内存，实现了Coster接口的时候使用Cost
+func cost(value Value) int64 {
+	if v, ok := value.(Coster); ok {
+		return v.Cost()
+	}
+	return int64(value.Len())
+}
+
+// priority 获取value的豁免次数，没有实现Prioritizer接口的时候为0，不超过MaxPriority
+func priority(value Value) int {
+	v, ok := value.(Prioritizer)
+	if !ok {
+		return 0
+	}
+	if p := v.Priority(); p < MaxPriority {
+		return p
+	}
+	return MaxPriority
+}
+
+// SetEntryOverhead 设置每条记录额外计入的内存，已有记录会按新的值重新统计
+func (c *Cache) SetEntryOverhead(overhead int64) {
+	c.nbytes += int64(c.ll.Len()) * (overhead - c.overhead)
+	c.overhead = overhead
+	c.evict()
+}
+
 // Len 获取cache中数据的长度
 func (c *Cache) Len() int {
 	return c.ll.Len()
-}
\ No newline at end of file
+}
+
+// Bytes 获取cache当前占用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// OldestTick 获取队尾节点最近一次被访问时的逻辑时钟，值越小说明越久没有被访问
+func (c *Cache) OldestTick() (tick uint64, ok bool) {
+	ele := c.ll.Back()
+	if ele == nil {
+		return
+	}
+	return ele.Value.(*entry).tick, true
+}
+
+// Range 从最久未被访问到最近被访问的顺序遍历所有记录，fn返回false时停止遍历，遍历不会改变记录的访问顺序
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		kv := ele.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
@@ -63,4 +63,102 @@ func TestOnEvicted(t *testing.T) {
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
-}
\ No newline at end of file
+}
+
+// TestEntryOverhead 测试开销统计开启后，内存按key+value+overhead计算，并且会触发淘汰
+func TestEntryOverhead(t *testing.T) {
+	cache := NewCache(int64(100), nil)
+	cache.Add("k1", String("v1"))
+	cache.Add("k2", String("v2"))
+	if cache.Bytes() != 8 {
+		t.Fatalf("expect 8 bytes, got %d", cache.Bytes())
+	}
+	cache.SetEntryOverhead(46)
+	// 每条记录 2 + 2 + 46 = 50 字节，两条刚好100字节
+	if cache.Bytes() != 100 || cache.Len() != 2 {
+		t.Fatalf("expect 100 bytes, got %d", cache.Bytes())
+	}
+	cache.Add("k3", String("v3"))
+	if _, ok := cache.GetValue("k1"); ok || cache.Bytes() != 100 {
+		t.Fatalf("RemoveOldest k1 failed")
+	}
+}
+
+// TestAddTooLarge 超过cache最大内存的记录不会写入，也不会淘汰其他记录
+func TestAddTooLarge(t *testing.T) {
+	cache := NewCache(int64(10), nil)
+	cache.Add("k1", String("v1"))
+	cache.Add("k2", String("0123456789"))
+	if _, ok := cache.GetValue("k1"); !ok || cache.Len() != 1 {
+		t.Fatalf("oversized value evicted k1")
+	}
+}
+
+// weighted 带有淘汰优先级和计入内存的value
+type weighted struct {
+	String
+	priority int
+	cost     int64
+}
+
+func (w weighted) Priority() int {
+	return w.priority
+}
+
+func (w weighted) Cost() int64 {
+	return w.cost
+}
+
+// TestPriorityAndCost 有豁免次数的记录淘汰时放回队首，实现了Coster的value按Cost计入内存
+func TestPriorityAndCost(t *testing.T) {
+	cache := NewCache(int64(20), nil)
+	cache.Add("k1", weighted{String: "v1", priority: 1, cost: 4})
+	cache.Add("k2", String("v2"))
+	if cache.Bytes() != 2+4+2+2 {
+		t.Fatalf("Bytes() = %d", cache.Bytes())
+	}
+	cache.Add("k3", String("0123456789"))
+	// k1是最久未被访问的，但还有一次豁免，淘汰的是k2
+	if _, ok := cache.GetValue("k2"); ok {
+		t.Fatalf("k2 should be evicted before k1")
+	}
+	if _, ok := cache.GetValue("k1"); !ok {
+		t.Fatalf("k1 should survive one eviction")
+	}
+
+	// 豁免次数不超过MaxPriority，所有记录的Priority都很大的时候淘汰也不会一直循环
+	cache = NewCache(int64(4), nil)
+	cache.Add("k1", weighted{String: "v1", priority: 1 << 30, cost: 2})
+	cache.Add("k2", weighted{String: "v2", priority: 1 << 30, cost: 2})
+	if _, ok := cache.GetValue("k1"); ok || cache.Len() != 1 {
+		t.Fatalf("k1 should be evicted after MaxPriority rounds")
+	}
+}
+
+// tagged 带有标签的value
+type tagged struct {
+	String
+	tags []string
+}
+
+func (v tagged) Tags() []string {
+	return v.tags
+}
+
+// TestRemoveTag 按标签删除记录，更新value之后索引使用新的标签
+func TestRemoveTag(t *testing.T) {
+	cache := NewCache(int64(0), nil)
+	cache.Add("k1", tagged{"v1", []string{"a", "b"}})
+	cache.Add("k2", tagged{"v2", []string{"a"}})
+	cache.Add("k3", String("v3"))
+	cache.Add("k2", tagged{"v2", []string{"b"}})
+	if n := cache.RemoveTag("a"); n != 1 || cache.Len() != 2 {
+		t.Fatalf("RemoveTag(a) = %d, Len() = %d", n, cache.Len())
+	}
+	if n := cache.RemoveTag("b"); n != 1 || cache.Len() != 1 {
+		t.Fatalf("RemoveTag(b) = %d, Len() = %d", n, cache.Len())
+	}
+	if len(cache.tags) != 0 {
+		t.Fatalf("empty tags should be dropped from the index: %v", cache.tags)
+	}
+}
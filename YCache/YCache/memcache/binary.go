@@ -0,0 +1,224 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package memcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	YCache "seven-days-projects/YCache/YCache"
+	"seven-days-projects/YCache/YCache/internal/tcpserver"
+)
+
+// 二进制协议的magic和header长度
+const (
+	reqMagic   = 0x80
+	resMagic   = 0x81
+	headerSize = 24
+)
+
+// 支持的二进制协议命令，Q结尾的是quiet命令，成功的时候不回复(GETQ/GETKQ是miss的时候不回复)
+const (
+	opGet     = 0x00
+	opSet     = 0x01
+	opDelete  = 0x04
+	opQuit    = 0x07
+	opGetQ    = 0x09
+	opNoop    = 0x0a
+	opVersion = 0x0b
+	opGetK    = 0x0c
+	opGetKQ   = 0x0d
+	opStat    = 0x10
+	opSetQ    = 0x11
+	opQuitQ   = 0x17
+	opDeleteQ = 0x14
+)
+
+// 二进制协议的响应状态
+const (
+	statusOK          = 0x00
+	statusKeyNotFound = 0x01
+	statusTooLarge    = 0x03
+	statusInvalidArgs = 0x04
+	statusNotStored   = 0x05
+	statusUnknownCmd  = 0x81
+	statusInternal    = 0x84
+)
+
+// header 二进制协议的请求头，响应头中vbucket的位置是status
+type header struct {
+	opcode  byte
+	keyLen  uint16
+	extLen  uint8
+	status  uint16
+	bodyLen uint32
+	opaque  uint32
+	cas     uint64
+}
+
+// serveBinary 处理二进制协议的命令
+func (s *Server) serveBinary(conn net.Conn, r *bufio.Reader, w *bufio.Writer) error {
+	buf := make([]byte, headerSize)
+	for {
+		if err := s.tcp.Next(conn, r); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		if buf[0] != reqMagic {
+			return errBadFormat
+		}
+		req := header{
+			opcode:  buf[1],
+			keyLen:  binary.BigEndian.Uint16(buf[2:]),
+			extLen:  buf[4],
+			bodyLen: binary.BigEndian.Uint32(buf[8:]),
+			opaque:  binary.BigEndian.Uint32(buf[12:]),
+			cas:     binary.BigEndian.Uint64(buf[16:]),
+		}
+		if req.bodyLen > maxValueSize || uint32(req.keyLen)+uint32(req.extLen) > req.bodyLen {
+			return errBadFormat
+		}
+		body, err := tcpserver.ReadN(r, int(req.bodyLen))
+		if err != nil {
+			return err
+		}
+		extras := body[:req.extLen]
+		key := string(body[req.extLen : uint32(req.extLen)+uint32(req.keyLen)])
+		value := body[uint32(req.extLen)+uint32(req.keyLen):]
+		if quit := s.executeBinary(w, &req, extras, key, value); quit {
+			return w.Flush()
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// executeBinary 执行一个二进制协议的命令，返回是否需要关闭连接
+func (s *Server) executeBinary(w *bufio.Writer, req *header, extras []byte, key string, value []byte) (quit bool) {
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		withKey := req.opcode == opGetK || req.opcode == opGetKQ
+		quiet := req.opcode == opGetQ || req.opcode == opGetKQ
+		view, err := s.get(key)
+		switch {
+		case errors.Is(err, YCache.ErrNotFound):
+			// quiet命令只有miss的时候不回复，出错的时候仍然需要回复
+			if !quiet {
+				writeStatus(w, req, statusKeyNotFound, withKey, key)
+			}
+			return
+		case errors.Is(err, errBadKey) || errors.Is(err, errNoGroup):
+			writeStatus(w, req, statusInvalidArgs, withKey, key)
+			return
+		case err != nil:
+			writeStatus(w, req, statusInternal, withKey, key)
+			return
+		}
+		res := header{opcode: req.opcode, extLen: 4, opaque: req.opaque, cas: cas(view)}
+		var k string
+		if withKey {
+			k = key
+		}
+		// extras是4字节的flags，原样返回set的时候保存的flags
+		flags := make([]byte, 4)
+		binary.BigEndian.PutUint32(flags, view.Flags())
+		writeResponse(w, &res, flags, k, view.ByteSlice())
+	case opSet, opSetQ:
+		if len(extras) != 8 {
+			writeStatus(w, req, statusInvalidArgs, false, "")
+			return
+		}
+		// extras是4字节的flags和4字节的exptime
+		flags := binary.BigEndian.Uint32(extras[:4])
+		exptime := int64(binary.BigEndian.Uint32(extras[4:]))
+		if err := s.set(key, value, exptime, flags); errors.Is(err, YCache.ErrTooLarge) {
+			writeStatus(w, req, statusTooLarge, false, "")
+			return
+		} else if err != nil {
+			writeStatus(w, req, statusNotStored, false, "")
+			return
+		}
+		if req.opcode == opSet {
+			writeStatus(w, req, statusOK, false, "")
+		}
+	case opDelete, opDeleteQ:
+		group, k, err := s.lookup(key)
+		if err != nil || !group.Remove(k) {
+			writeStatus(w, req, statusKeyNotFound, false, "")
+			return
+		}
+		if req.opcode == opDelete {
+			writeStatus(w, req, statusOK, false, "")
+		}
+	case opStat:
+		// 每个统计项一个响应，最后是key为空的响应
+		s.stats(func(name, value string) {
+			writeResponse(w, &header{opcode: opStat, opaque: req.opaque}, nil, name, []byte(value))
+		})
+		writeStatus(w, req, statusOK, false, "")
+	case opNoop:
+		writeStatus(w, req, statusOK, false, "")
+	case opVersion:
+		writeResponse(w, &header{opcode: opVersion, opaque: req.opaque}, nil, "", []byte(version))
+	case opQuit:
+		writeStatus(w, req, statusOK, false, "")
+		return true
+	case opQuitQ:
+		return true
+	default:
+		writeStatus(w, req, statusUnknownCmd, false, "")
+	}
+	return
+}
+
+// writeStatus 写入只有状态的响应，出错的时候value中是错误信息
+func writeStatus(w *bufio.Writer, req *header, status uint16, withKey bool, key string) {
+	res := header{opcode: req.opcode, status: status, opaque: req.opaque}
+	var value []byte
+	switch status {
+	case statusKeyNotFound:
+		value = []byte("Not found")
+	case statusTooLarge:
+		value = []byte("Too large")
+	case statusInvalidArgs:
+		value = []byte("Invalid arguments")
+	case statusNotStored:
+		value = []byte("Not stored")
+	case statusUnknownCmd:
+		value = []byte("Unknown command")
+	case statusInternal:
+		value = []byte("Internal error")
+	}
+	if !withKey {
+		key = ""
+	}
+	writeResponse(w, &res, nil, key, value)
+}
+
+// writeResponse 写入二进制协议的响应
+func writeResponse(w *bufio.Writer, res *header, extras []byte, key string, value []byte) {
+	buf := make([]byte, headerSize)
+	buf[0] = resMagic
+	buf[1] = res.opcode
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(key)))
+	buf[4] = uint8(len(extras))
+	binary.BigEndian.PutUint16(buf[6:], res.status)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(buf[12:], res.opaque)
+	binary.BigEndian.PutUint64(buf[16:], res.cas)
+	w.Write(buf)
+	w.Write(extras)
+	w.WriteString(key)
+	w.Write(value)
+}
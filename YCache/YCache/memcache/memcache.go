@@ -0,0 +1,293 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package memcache 兼容memcached协议的前端服务，同时支持文本协议和二进制协议
+// 使用memcached客户端的服务不需要修改代码就可以迁移到YCache
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"os"
+	YCache "seven-days-projects/YCache/YCache"
+	"seven-days-projects/YCache/YCache/internal/tcpserver"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxKeyLength = 250      // memcached协议中key的最大长度
+	maxValueSize = 64 << 20 // 单个value的最大长度
+	// relativeExpire exptime不超过30天时表示相对时间，超过时表示unix时间戳
+	relativeExpire = 60 * 60 * 24 * 30
+	version        = "YCache-1.0"
+)
+
+var (
+	errBadKey    = errors.New("bad key")
+	errNoGroup   = errors.New("no such group")
+	errBadFormat = errors.New("bad command line format")
+)
+
+// Server memcached协议的前端服务
+type Server struct {
+	// DefaultGroup 不为空的时候，所有key都属于这个group，不需要加上group前缀
+	// 为空的时候key的格式为"group:key"
+	DefaultGroup string
+
+	start time.Time
+	tcp   *tcpserver.Server
+}
+
+// NewServer 创建memcached协议的前端服务，defaultGroup为空表示key中带有group前缀
+func NewServer(defaultGroup string) *Server {
+	s := &Server{DefaultGroup: defaultGroup, start: time.Now()}
+	s.tcp = tcpserver.New("memcache", s.serveConn)
+	return s
+}
+
+// ListenAndServe 监听addr并处理请求，阻塞直到出错或者Close
+func (s *Server) ListenAndServe(addr string) error {
+	return s.tcp.ListenAndServe(addr)
+}
+
+// Serve 处理ln上的连接，阻塞直到出错或者Close
+func (s *Server) Serve(ln net.Listener) error {
+	return s.tcp.Serve(ln)
+}
+
+// Shutdown 停止接受新连接，等待正在执行的命令完成之后关闭连接，ctx超时之后直接关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.tcp.Shutdown(ctx)
+}
+
+// Close 关闭所有监听和连接
+func (s *Server) Close() error {
+	return s.tcp.Close()
+}
+
+// serveConn 根据第一个字节判断连接使用的是文本协议还是二进制协议
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	if err := s.tcp.Next(conn, r); err != nil {
+		return
+	}
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == reqMagic {
+		err = s.serveBinary(conn, r, w)
+	} else {
+		err = s.serveText(conn, r, w)
+	}
+	if err != nil && err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) && !s.tcp.Closed() {
+		log.Println("[YCache] memcache connection failed:", err)
+	}
+}
+
+// serveText 处理文本协议的命令
+func (s *Server) serveText(conn net.Conn, r *bufio.Reader, w *bufio.Writer) error {
+	for {
+		if err := s.tcp.Next(conn, r); err != nil {
+			return err
+		}
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			return w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if quit, err := s.executeText(r, w, fields); err != nil || quit {
+			w.Flush()
+			return err
+		}
+		// 客户端没有更多的命令时才flush，pipeline的多个回复一次写出
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// executeText 执行一个文本协议的命令，返回是否需要关闭连接
+func (s *Server) executeText(r *bufio.Reader, w *bufio.Writer, fields []string) (quit bool, err error) {
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			return
+		}
+		for _, name := range args {
+			view, err := s.get(name)
+			if errors.Is(err, YCache.ErrNotFound) {
+				continue
+			}
+			// 和memcached一样，出错之后不再返回END，客户端把错误当作整个命令的回复
+			if errors.Is(err, errBadKey) || errors.Is(err, errNoGroup) {
+				w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+				return false, nil
+			}
+			if err != nil {
+				w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+				return false, nil
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", name, view.Flags(), view.Len(), cas(view))
+			} else {
+				fmt.Fprintf(w, "VALUE %s %d %d\r\n", name, view.Flags(), view.Len())
+			}
+			view.WriteTo(w)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "set":
+		// set <key> <flags> <exptime> <bytes> [noreply]
+		if len(args) != 4 && len(args) != 5 {
+			w.WriteString("ERROR\r\n")
+			return
+		}
+		noreply := len(args) == 5 && args[4] == "noreply"
+		flags, err0 := strconv.ParseUint(args[1], 10, 32)
+		exptime, err1 := strconv.ParseInt(args[2], 10, 64)
+		size, err2 := strconv.Atoi(args[3])
+		if err0 != nil || err1 != nil || err2 != nil || size < 0 || size > maxValueSize {
+			w.WriteString("CLIENT_ERROR " + errBadFormat.Error() + "\r\n")
+			return true, nil
+		}
+		var value []byte
+		if value, err = tcpserver.ReadN(r, size+2); err != nil {
+			return true, err
+		}
+		if value[size] != '\r' || value[size+1] != '\n' {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return
+		}
+		reply := "STORED"
+		if err := s.set(args[0], value[:size], exptime, uint32(flags)); errors.Is(err, YCache.ErrTooLarge) {
+			reply = "SERVER_ERROR object too large for cache"
+		} else if err != nil {
+			reply = "CLIENT_ERROR " + err.Error()
+		}
+		if !noreply {
+			w.WriteString(reply + "\r\n")
+		}
+	case "delete":
+		if len(args) != 1 && len(args) != 2 {
+			w.WriteString("ERROR\r\n")
+			return
+		}
+		reply := "NOT_FOUND"
+		if group, key, err := s.lookup(args[0]); err != nil {
+			reply = "CLIENT_ERROR " + err.Error()
+		} else if group.Remove(key) {
+			reply = "DELETED"
+		}
+		if len(args) != 2 || args[1] != "noreply" {
+			w.WriteString(reply + "\r\n")
+		}
+	case "stats":
+		s.stats(func(name, value string) {
+			fmt.Fprintf(w, "STAT %s %s\r\n", name, value)
+		})
+		w.WriteString("END\r\n")
+	case "version":
+		w.WriteString("VERSION " + version + "\r\n")
+	case "quit":
+		return true, nil
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return
+}
+
+// lookup 根据key找到对应的group，没有设置DefaultGroup的时候key的格式为"group:key"
+func (s *Server) lookup(name string) (*YCache.Group, string, error) {
+	if name == "" || len(name) > maxKeyLength {
+		return nil, "", errBadKey
+	}
+	groupName, key := s.DefaultGroup, name
+	if groupName == "" {
+		i := strings.IndexByte(name, ':')
+		if i <= 0 || i == len(name)-1 {
+			return nil, "", errBadKey
+		}
+		groupName, key = name[:i], name[i+1:]
+	}
+	group := YCache.GetGroup(groupName)
+	if group == nil {
+		return nil, "", errNoGroup
+	}
+	return group, key, nil
+}
+
+// get 获取value，key不存在的时候返回YCache.ErrNotFound，key格式不正确的时候返回errBadKey或errNoGroup
+func (s *Server) get(name string) (*YCache.ByteView, error) {
+	group, key, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return group.Get(key)
+}
+
+// set 写入value和客户端的flags，exptime的含义和memcached一致：0表示不过期，负数表示立即过期
+func (s *Server) set(name string, value []byte, exptime int64, flags uint32) error {
+	group, key, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	switch {
+	case exptime < 0:
+		group.Remove(key)
+		return nil
+	case exptime > relativeExpire:
+		ttl = time.Until(time.Unix(exptime, 0))
+		if ttl <= 0 {
+			group.Remove(key)
+			return nil
+		}
+	case exptime > 0:
+		ttl = time.Duration(exptime) * time.Second
+	}
+	return group.SetWithOptions(key, value, YCache.LoadOptions{TTL: ttl, Flags: flags})
+}
+
+// stats 输出服务的基本信息和每个group的计数器，计数器的名称为"group:counter"
+func (s *Server) stats(fn func(name, value string)) {
+	now := time.Now()
+	fn("pid", strconv.Itoa(os.Getpid()))
+	fn("uptime", strconv.FormatInt(int64(now.Sub(s.start).Seconds()), 10))
+	fn("time", strconv.FormatInt(now.Unix(), 10))
+	fn("version", version)
+	fn("curr_connections", strconv.Itoa(s.tcp.Connections()))
+	for _, name := range YCache.GroupNames() {
+		YCache.GetGroup(name).Stats.Each(func(counter string, value int64) {
+			fn(name+":"+counter, strconv.FormatInt(value, 10))
+		})
+	}
+}
+
+// cas gets命令返回的cas值，由value的版本计算得到，value变化之后cas也会变化
+func cas(view *YCache.ByteView) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(view.Version()))
+	return h.Sum64()
+}
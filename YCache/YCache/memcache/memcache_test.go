@@ -0,0 +1,209 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package memcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	YCache "seven-days-projects/YCache/YCache"
+	"strings"
+	"testing"
+	"time"
+)
+
+var db = map[string]string{"Tom": "630", "Jack": "589"}
+
+func init() {
+	YCache.NewGroup("mc-scores", 2<<10, YCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		if key == "Broken" {
+			return nil, errors.New("backend unavailable")
+		}
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}))
+}
+
+// dial 启动服务并建立一个连接
+func dial(t *testing.T, srv *Server) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readUntil 读取文本协议的回复，直到以end结尾的行
+func readUntil(t *testing.T, r *bufio.Reader, end string) string {
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString(line)
+		if strings.HasSuffix(line, end+"\r\n") {
+			return b.String()
+		}
+	}
+}
+
+func TestTextProtocol(t *testing.T) {
+	srv := NewServer("")
+	defer srv.Close()
+	conn := dial(t, srv)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("get mc-scores:Tom mc-scores:Sam mc-scores:Jack\r\n"))
+	want := "VALUE mc-scores:Tom 0 3\r\n630\r\nVALUE mc-scores:Jack 0 3\r\n589\r\nEND\r\n"
+	if got := readUntil(t, r, "END"); got != want {
+		t.Fatalf("multi-key get: got %q", got)
+	}
+
+	conn.Write([]byte("set mc-scores:Sam 0 60 3\r\n567\r\n"))
+	if got := readUntil(t, r, "STORED"); got != "STORED\r\n" {
+		t.Fatalf("set: got %q", got)
+	}
+	conn.Write([]byte("set mc-scores:Big 0 0 4096\r\n" + strings.Repeat("x", 4096) + "\r\n"))
+	if got := readUntil(t, r, "cache"); got != "SERVER_ERROR object too large for cache\r\n" {
+		t.Fatalf("set too large: got %q", got)
+	}
+	conn.Write([]byte("set mc-scores:Flagged 42 0 2\r\nhi\r\nget mc-scores:Flagged\r\n"))
+	if got := readUntil(t, r, "STORED") + readUntil(t, r, "END"); got != "STORED\r\nVALUE mc-scores:Flagged 42 2\r\nhi\r\nEND\r\n" {
+		t.Fatalf("flags should be returned by get: got %q", got)
+	}
+	conn.Write([]byte("get mc-scores:Broken\r\n"))
+	if got := readUntil(t, r, "unavailable"); got != "SERVER_ERROR backend unavailable\r\n" {
+		t.Fatalf("load error should not be a miss: got %q", got)
+	}
+	conn.Write([]byte("gets mc-scores:Sam\r\n"))
+	if got := readUntil(t, r, "END"); !strings.HasPrefix(got, "VALUE mc-scores:Sam 0 3 ") || !strings.Contains(got, "\r\n567\r\n") {
+		t.Fatalf("gets should return a cas value: got %q", got)
+	}
+
+	// noreply的set没有回复，下一条命令的回复紧接着返回
+	conn.Write([]byte("set mc-scores:Kate 0 0 2 noreply\r\n99\r\ndelete mc-scores:Kate\r\ndelete mc-scores:Kate\r\n"))
+	if got := readUntil(t, r, "DELETED") + readUntil(t, r, "NOT_FOUND"); got != "DELETED\r\nNOT_FOUND\r\n" {
+		t.Fatalf("delete: got %q", got)
+	}
+
+	conn.Write([]byte("stats\r\n"))
+	if got := readUntil(t, r, "END"); !strings.Contains(got, "STAT mc-scores:gets ") {
+		t.Fatalf("stats should report group counters: got %q", got)
+	}
+	conn.Write([]byte("flush_all\r\n"))
+	if got := readUntil(t, r, "ERROR"); got != "ERROR\r\n" {
+		t.Fatalf("unknown command: got %q", got)
+	}
+}
+
+// binaryRequest 构造二进制协议的请求
+func binaryRequest(opcode byte, extras []byte, key, value string) []byte {
+	buf := make([]byte, headerSize)
+	buf[0] = reqMagic
+	buf[1] = opcode
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(key)))
+	buf[4] = uint8(len(extras))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(extras)+len(key)+len(value)))
+	buf = append(buf, extras...)
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+// readBinary 读取二进制协议的响应，返回status、key和value
+func readBinary(t *testing.T, r io.Reader) (status uint16, key, value string) {
+	status, _, key, value = readBinaryExtras(t, r)
+	return
+}
+
+// readBinaryExtras 读取二进制协议的响应，同时返回extras
+func readBinaryExtras(t *testing.T, r io.Reader) (status uint16, extras []byte, key, value string) {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != resMagic {
+		t.Fatalf("bad response magic %x", buf[0])
+	}
+	keyLen := int(binary.BigEndian.Uint16(buf[2:]))
+	extLen := int(buf[4])
+	body := make([]byte, binary.BigEndian.Uint32(buf[8:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	return binary.BigEndian.Uint16(buf[6:]), body[:extLen], string(body[extLen : extLen+keyLen]), string(body[extLen+keyLen:])
+}
+
+func TestBinaryProtocol(t *testing.T) {
+	// 设置了DefaultGroup，key不需要group前缀
+	srv := NewServer("mc-scores")
+	defer srv.Close()
+	conn := dial(t, srv)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write(binaryRequest(opGetK, nil, "Tom", ""))
+	if status, key, value := readBinary(t, r); status != statusOK || key != "Tom" || value != "630" {
+		t.Fatalf("getk: got %d %q %q", status, key, value)
+	}
+	conn.Write(binaryRequest(opGet, nil, "Lily", ""))
+	if status, _, _ := readBinary(t, r); status != statusKeyNotFound {
+		t.Fatalf("get missing key: got status %d", status)
+	}
+
+	// GETQ出错的时候仍然回复
+	conn.Write(binaryRequest(opGetQ, nil, "Broken", ""))
+	if status, _, _ := readBinary(t, r); status != statusInternal {
+		t.Fatalf("get failing key: got status %d", status)
+	}
+
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras, 7)
+	binary.BigEndian.PutUint32(extras[4:], 60)
+	conn.Write(binaryRequest(opSet, extras, "Lily", "601"))
+	if status, _, _ := readBinary(t, r); status != statusOK {
+		t.Fatalf("set: got status %d", status)
+	}
+	// GETQ miss不回复，NOOP的回复说明前面的命令已经处理完了
+	conn.Write(append(append(binaryRequest(opGetQ, nil, "Lucy", ""), binaryRequest(opGet, nil, "Lily", "")...), binaryRequest(opNoop, nil, "", "")...))
+	if status, extras, _, value := readBinaryExtras(t, r); status != statusOK || value != "601" || binary.BigEndian.Uint32(extras) != 7 {
+		t.Fatalf("get after set: got %d %v %q", status, extras, value)
+	}
+	if status, _, _ := readBinary(t, r); status != statusOK {
+		t.Fatalf("noop: got status %d", status)
+	}
+
+	conn.Write(binaryRequest(opDelete, nil, "Lily", ""))
+	if status, _, _ := readBinary(t, r); status != statusOK {
+		t.Fatalf("delete: got status %d", status)
+	}
+
+	conn.Write(binaryRequest(opStat, nil, "", ""))
+	found := false
+	for {
+		_, key, _ := readBinary(t, r)
+		if key == "" {
+			break
+		}
+		found = found || key == "mc-scores:gets"
+	}
+	if !found {
+		t.Fatalf("stat should report group counters")
+	}
+}
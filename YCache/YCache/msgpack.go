@@ -0,0 +1,657 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 这里是MessagePack的一个精简实现，供MsgpackCodec使用，支持：
+// nil、bool、整数、浮点数、string、[]byte、slice、array、map、struct和指针，
+// 实现了encoding.BinaryMarshaler的类型(例如time.Time)编码为bin，
+// map的key只支持string、整数、浮点数和布尔值
+
+var (
+	errMsgpackShort = errors.New("msgpack: unexpected end of data")
+	binaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+)
+
+// msgpackMarshal 编码v
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// msgpackUnmarshal 解码到v，v必须是非nil的指针
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal requires a non-nil pointer")
+	}
+	d := &msgpackDecoder{buf: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("msgpack: %d bytes of trailing data", len(d.buf))
+	}
+	return nil
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) byte1(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+// head 写入类型标记和长度，n为1、2、4、8字节
+func (e *msgpackEncoder) head(code byte, v uint64, n int) {
+	e.buf = append(e.buf, code)
+	for i := n - 1; i >= 0; i-- {
+		e.buf = append(e.buf, byte(v>>(8*uint(i))))
+	}
+}
+
+// length 写入str、bin、array、map的长度，fix为fix格式的标记，codes为8、16、32位长度格式的标记
+func (e *msgpackEncoder) length(n int, fix byte, fixMax int, codes [3]byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.byte1(fix | byte(n))
+	case codes[0] != 0 && n <= math.MaxUint8:
+		e.head(codes[0], uint64(n), 1)
+	case n <= math.MaxUint16:
+		e.head(codes[1], uint64(n), 2)
+	default:
+		e.head(codes[2], uint64(n), 4)
+	}
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.byte1(0xc0)
+		return nil
+	}
+	if v.Type().Implements(binaryMarshaler) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(b)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.byte1(0xc3)
+		} else {
+			e.byte1(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.head(0xca, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		e.head(0xcb, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		e.length(v.Len(), 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb})
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		return e.array(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		// 只支持标量类型的key，解码的时候直接解码为key的类型
+		if !msgpackMapKey(v.Type().Key().Kind()) {
+			return fmt.Errorf("msgpack: unsupported map key type %v", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		// key排序之后编码，相同的map编码结果相同
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		e.length(len(keys), 0x80, 15, [3]byte{0, 0xde, 0xdf})
+		for _, k := range keys {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := msgpackFields(v.Type())
+		e.length(len(fields), 0x80, 15, [3]byte{0, 0xde, 0xdf})
+		for _, f := range fields {
+			e.length(len(f.name), 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb})
+			e.buf = append(e.buf, f.name...)
+			if err := e.encode(v.Field(f.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.byte1(byte(n))
+	case n >= math.MinInt8:
+		e.head(0xd0, uint64(n), 1)
+	case n >= math.MinInt16:
+		e.head(0xd1, uint64(n), 2)
+	case n >= math.MinInt32:
+		e.head(0xd2, uint64(n), 4)
+	default:
+		e.head(0xd3, uint64(n), 8)
+	}
+}
+
+func (e *msgpackEncoder) uint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.byte1(byte(n))
+	case n <= math.MaxUint8:
+		e.head(0xcc, n, 1)
+	case n <= math.MaxUint16:
+		e.head(0xcd, n, 2)
+	case n <= math.MaxUint32:
+		e.head(0xce, n, 4)
+	default:
+		e.head(0xcf, n, 8)
+	}
+}
+
+func (e *msgpackEncoder) bytes(b []byte) {
+	e.length(len(b), 0, 0, [3]byte{0xc4, 0xc5, 0xc6})
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) array(v reflect.Value) error {
+	e.length(v.Len(), 0x90, 15, [3]byte{0, 0xdc, 0xdd})
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackField 结构体中参与编码的字段
+type msgpackField struct {
+	name  string
+	index int
+}
+
+// msgpackFields 获取结构体中导出的字段，tag为"-"的字段跳过
+func msgpackFields(t reflect.Type) []msgpackField {
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("msgpack"); tag != "" {
+			if tag = strings.Split(tag, ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, msgpackField{name, i})
+	}
+	return fields
+}
+
+type msgpackDecoder struct {
+	buf []byte
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if len(d.buf) < n {
+		return nil, errMsgpackShort
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// uintN 读取n字节的大端整数
+func (d *msgpackDecoder) uintN(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// decodeAny 解码为通用的Go类型：nil、bool、int64、uint64、float64、string、[]byte、[]interface{}、map[string]interface{}或map[interface{}]interface{}
+func (d *msgpackDecoder) decodeAny() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		s, err := d.next(int(c & 0x1f))
+		return string(s), err
+	case c&0xf0 == 0x90:
+		return d.anyArray(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.anyMap(int(c & 0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uintN(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := d.uintN(n)
+		// 符号扩展
+		shift := uint(64 - 8*n)
+		return int64(u<<shift) >> shift, err
+	case 0xca:
+		u, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uintN(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n))
+		return string(s), err
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n))
+		return append([]byte(nil), s...), err
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.anyArray(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.anyMap(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%02x", c)
+}
+
+func (d *msgpackDecoder) anyArray(n int) (interface{}, error) {
+	if n > len(d.buf) {
+		return nil, errMsgpackShort
+	}
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+// anyMap 解码为map，key都是string或者[]byte的时候返回map[string]interface{}，[]byte的key按原样转换为string
+// 有其他类型的key的时候返回map[interface{}]interface{}，key保持解码得到的类型，不转换为字符串，避免1和"1"冲突
+func (d *msgpackDecoder) anyMap(n int) (interface{}, error) {
+	if n > len(d.buf) {
+		return nil, errMsgpackShort
+	}
+	keys, values := make([]interface{}, n), make([]interface{}, n)
+	scalar := false
+	for i := 0; i < n; i++ {
+		k, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		switch key := k.(type) {
+		case string:
+		case []byte:
+			k = string(key)
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("msgpack: unsupported map key type %T", k)
+		default:
+			scalar = true
+		}
+		if values[i], err = d.decodeAny(); err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	if scalar {
+		m := make(map[interface{}]interface{}, n)
+		for i, k := range keys {
+			m[k] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[string]interface{}, n)
+	for i, k := range keys {
+		m[k.(string)] = values[i]
+	}
+	return m, nil
+}
+
+// peek 查看下一个字节，不移动位置
+func (d *msgpackDecoder) peek() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, errMsgpackShort
+	}
+	return d.buf[0], nil
+}
+
+// msgpackIsMap 判断格式字节是否是map
+func msgpackIsMap(c byte) bool {
+	return c&0xf0 == 0x80 || c == 0xde || c == 0xdf
+}
+
+// msgpackIsArray 判断格式字节是否是array
+func msgpackIsArray(c byte) bool {
+	return c&0xf0 == 0x90 || c == 0xdc || c == 0xdd
+}
+
+// length 读取map或array的长度，fix是短格式的前缀，code16是16位长度的格式字节，32位长度的格式字节紧随其后
+func (d *msgpackDecoder) length(fix, code16 byte) (int, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	n := uint64(b[0] & 0x0f)
+	if c := b[0]; c&0xf0 != fix {
+		if n, err = d.uintN(2 << int(c-code16)); err != nil {
+			return 0, err
+		}
+	}
+	// 每个元素至少占用1个字节，长度超过剩余的数据说明数据不完整，避免按照长度预先分配
+	if n > uint64(len(d.buf)) {
+		return 0, errMsgpackShort
+	}
+	return int(n), nil
+}
+
+// decode 按照v的类型解码，map、struct、slice和array直接解码到v中，其他类型先解码为通用类型再赋值
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if c == 0xc0 {
+		d.buf = d.buf[1:]
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	}
+	switch {
+	case msgpackIsMap(c) && v.Kind() == reflect.Map && msgpackMapKey(v.Type().Key().Kind()):
+		return d.decodeMap(v)
+	case msgpackIsMap(c) && v.Kind() == reflect.Struct:
+		return d.decodeStruct(v)
+	case msgpackIsArray(c) && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array):
+		return d.decodeArray(v)
+	}
+	x, err := d.decodeAny()
+	if err != nil {
+		return err
+	}
+	return assign(v, x)
+}
+
+// decodeMap 解码map，key直接解码为v的key类型，类型不一致的时候返回错误
+func (d *msgpackDecoder) decodeMap(v reflect.Value) error {
+	n, err := d.length(0x80, 0xde)
+	if err != nil {
+		return err
+	}
+	out := reflect.MakeMapWithSize(v.Type(), n)
+	for i := 0; i < n; i++ {
+		key := reflect.New(v.Type().Key()).Elem()
+		if err := d.decode(key); err != nil {
+			return err
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.decode(elem); err != nil {
+			return err
+		}
+		out.SetMapIndex(key, elem)
+	}
+	v.Set(out)
+	return nil
+}
+
+// decodeStruct 解码struct，不认识的字段忽略，新版本增加的字段不影响旧版本解码
+func (d *msgpackDecoder) decodeStruct(v reflect.Value) error {
+	n, err := d.length(0x80, 0xde)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]int)
+	for _, f := range msgpackFields(v.Type()) {
+		fields[f.name] = f.index
+	}
+	for i := 0; i < n; i++ {
+		var name string
+		if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return err
+		}
+		index, ok := fields[name]
+		if !ok {
+			if _, err := d.decodeAny(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Field(index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeArray 解码slice和array，array的长度必须和数据一致
+func (d *msgpackDecoder) decodeArray(v reflect.Value) error {
+	n, err := d.length(0x90, 0xdc)
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Array {
+		if n != v.Len() {
+			return fmt.Errorf("msgpack: cannot decode array of %d elements into %v", n, v.Type())
+		}
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		if err := d.decode(s.Index(i)); err != nil {
+			return err
+		}
+	}
+	v.Set(s)
+	return nil
+}
+
+// assign 把decodeAny的结果赋值给标量、interface{}、[]byte和实现了encoding.BinaryUnmarshaler的v
+func assign(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if b, ok := x.([]byte); ok && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.BinaryUnmarshaler); ok {
+			return u.UnmarshalBinary(b)
+		}
+	}
+	mismatch := fmt.Errorf("msgpack: cannot decode %T into %v", x, v.Type())
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return mismatch
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch x := x.(type) {
+		case int64:
+			n = x
+		case uint64:
+			if x > math.MaxInt64 {
+				return mismatch
+			}
+			n = int64(x)
+		default:
+			return mismatch
+		}
+		if v.OverflowInt(n) {
+			return mismatch
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := x.(type) {
+		case uint64:
+			n = x
+		case int64:
+			if x < 0 {
+				return mismatch
+			}
+			n = uint64(x)
+		default:
+			return mismatch
+		}
+		if v.OverflowUint(n) {
+			return mismatch
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := x.(type) {
+		case float64:
+			v.SetFloat(x)
+		case int64:
+			v.SetFloat(float64(x))
+		case uint64:
+			v.SetFloat(float64(x))
+		default:
+			return mismatch
+		}
+	case reflect.String:
+		switch x := x.(type) {
+		case string:
+			v.SetString(x)
+		case []byte:
+			v.SetString(string(x))
+		default:
+			return mismatch
+		}
+	case reflect.Slice:
+		// map、struct、slice和array在decode中直接解码，这里只处理bin和str解码为[]byte
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return mismatch
+		}
+		switch x := x.(type) {
+		case []byte:
+			v.SetBytes(x)
+		case string:
+			v.SetBytes([]byte(x))
+		default:
+			return mismatch
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// msgpackMapKey 判断map的key能否编码，只支持字符串、整数、浮点数和布尔值，解码的时候按原来的类型还原
+func msgpackMapKey(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
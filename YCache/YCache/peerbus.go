@@ -0,0 +1,452 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"net/http"
+	"net/url"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// eventsPath 失效事件的路由，在basePath之下，group不能使用这个名称
+	// POST接收其他节点发布的事件，GET返回当前节点最近发布的事件
+	eventsPath = "_events"
+
+	// defaultEventLogSize 默认保留的最近事件的个数
+	defaultEventLogSize = 1024
+)
+
+// PeerBusOptions PeerBus的配置，零值使用默认配置
+type PeerBusOptions struct {
+	// LogSize 保留最近多少个事件，用于其他节点补齐错过的事件，默认是1024
+	LogSize int
+
+	// SyncInterval 定期向其他节点拉取错过的事件，0表示只在收到的事件和之前的事件之间有缺口时拉取
+	// 节点最后几个事件丢失的时候，不会再有后续事件暴露缺口，需要定期拉取才能发现
+	SyncInterval time.Duration
+}
+
+// BusStats PeerBus的统计信息
+type BusStats struct {
+	Published  AtomicInt // 当前节点发布的事件
+	Received   AtomicInt // 交给订阅者的其他节点的事件，包括补齐的事件
+	Duplicates AtomicInt // 重复收到并被丢弃的事件
+	Stale      AtomicInt // 发布方重启之前发出、重启之后才收到并被丢弃的事件
+	Gaps       AtomicInt // 发现缺口的次数
+	Resets     AtomicInt // 事件无法补齐，通知订阅者重置的次数
+}
+
+// busCursor 已经交给订阅者的某个节点的最新事件
+type busCursor struct {
+	epoch int64
+	last  uint64
+	// synced 是否已经在当前epoch中记录过位置，第一次同步的节点和刚重启过的节点没有可以丢失的事件，缺口不需要Reset
+	synced bool
+}
+
+// PeerBus 基于HTTPPool的InvalidationBus，Publish把事件发送给HTTPPool中的其他所有节点
+// 每个节点保留最近发布的事件，接收方发现seq不连续的时候向发布方拉取缺失的事件，
+// 缺失的事件已经被淘汰、或者发布方重启过的时候，交给订阅者一个Reset事件
+type PeerBus struct {
+	pool  *HTTPPool
+	opts  PeerBusOptions
+	epoch int64
+	subs  subscribers
+	Stats BusStats
+
+	mu  sync.Mutex // 保护seq和log
+	seq uint64
+	log []InvalidationEvent // 最近发布的事件，按seq递增
+
+	deliverMu sync.Mutex            // 保证事件按顺序交给订阅者，同时保护cursors
+	cursors   map[string]*busCursor // 每个节点已经交给订阅者的最新事件
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPeerBus 创建PeerBus并注册到pool，pool开始处理失效事件的请求，opts为nil的时候使用默认配置
+func NewPeerBus(pool *HTTPPool, opts *PeerBusOptions) *PeerBus {
+	b := &PeerBus{
+		pool:    pool,
+		epoch:   time.Now().UnixNano(),
+		cursors: make(map[string]*busCursor),
+		stop:    make(chan struct{}),
+	}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.LogSize <= 0 {
+		b.opts.LogSize = defaultEventLogSize
+	}
+	pool.mu.Lock()
+	if pool.bus != nil {
+		pool.mu.Unlock()
+		panic("NewPeerBus called more than once for the same pool")
+	}
+	pool.bus = b
+	pool.mu.Unlock()
+	if b.opts.SyncInterval > 0 {
+		go b.syncLoop(b.opts.SyncInterval)
+	}
+	return b
+}
+
+// Node 实现InvalidationBus接口，返回HTTPPool中当前节点的地址
+func (b *PeerBus) Node() string {
+	return b.pool.self
+}
+
+// Subscribe 实现InvalidationBus接口
+func (b *PeerBus) Subscribe(fn func(e InvalidationEvent)) {
+	b.subs.add(fn)
+}
+
+// Publish 实现InvalidationBus接口，先交给当前节点的订阅者，再并发发送给其他节点
+// 发送失败的时候返回第一个错误，接收方会在下一个事件或者定期拉取的时候补齐
+func (b *PeerBus) Publish(e InvalidationEvent) error {
+	b.deliverMu.Lock()
+	b.mu.Lock()
+	b.seq++
+	e.Source, e.Epoch, e.Seq, e.Reset = b.pool.self, b.epoch, b.seq, false
+	if len(b.log) == b.opts.LogSize {
+		copy(b.log, b.log[1:])
+		b.log = b.log[:len(b.log)-1]
+	}
+	b.log = append(b.log, e)
+	b.mu.Unlock()
+	b.subs.deliver(e)
+	b.deliverMu.Unlock()
+	b.Stats.Published.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultBroadcastTimeout)
+	defer cancel()
+	query := eventQuery(e)
+	return b.pool.broadcast("publish invalidation", func(peer string) error {
+		return b.pool.post(ctx, peer, eventsPath, query)
+	})
+}
+
+// Sync 向其他所有节点拉取错过的事件，返回第一个失败的错误
+func (b *PeerBus) Sync(ctx context.Context) error {
+	return b.pool.broadcast("sync invalidations", func(peer string) error {
+		b.deliverMu.Lock()
+		var since uint64
+		if c := b.cursors[peer]; c != nil {
+			since = c.last
+		}
+		b.deliverMu.Unlock()
+		// 拉取的时候不持有锁，避免阻塞其他节点的事件，应用的时候按照最新的cursor跳过已经交给订阅者的事件
+		events, err := b.fetch(ctx, peer, since)
+		if err != nil {
+			return err
+		}
+		b.deliverMu.Lock()
+		defer b.deliverMu.Unlock()
+		b.apply(b.cursor(peer), peer, events)
+		return nil
+	})
+}
+
+// Close 停止定期拉取，并且不再处理其他节点的事件请求
+func (b *PeerBus) Close() error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+		b.pool.mu.Lock()
+		if b.pool.bus == b {
+			b.pool.bus = nil
+		}
+		b.pool.mu.Unlock()
+	})
+	return nil
+}
+
+// syncLoop 定期拉取错过的事件，直到Close
+func (b *PeerBus) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), defaultBroadcastTimeout)
+			if err := b.Sync(ctx); err != nil {
+				b.pool.Log("%v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// cursor 获取节点的cursor，调用方需要持有deliverMu
+func (b *PeerBus) cursor(source string) *busCursor {
+	c := b.cursors[source]
+	if c == nil {
+		c = &busCursor{}
+		b.cursors[source] = c
+	}
+	return c
+}
+
+// receive 处理其他节点发送的事件：重复的事件和发布方重启之前的事件直接丢弃，有缺口的时候先向发布方拉取缺失的事件
+// 拉取的时候不持有deliverMu，避免阻塞其他节点的事件和当前节点的Publish，拉取之后按照最新的cursor处理
+func (b *PeerBus) receive(e InvalidationEvent) {
+	b.deliverMu.Lock()
+	c := b.cursor(e.Source)
+	if b.stale(c, e) || e.Seq <= c.last+1 {
+		b.deliver(c, e)
+		b.deliverMu.Unlock()
+		return
+	}
+	b.Stats.Gaps.Add(1)
+	since := c.last
+	b.deliverMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultBroadcastTimeout)
+	events, err := b.fetch(ctx, e.Source, since)
+	cancel()
+
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+	if b.stale(c, e) {
+		return
+	}
+	if err != nil {
+		b.pool.Log("fetch invalidations from %s: %v", e.Source, err)
+	} else if events.Epoch == c.epoch {
+		b.apply(c, e.Source, events)
+	}
+	// 仍然没有补齐，之前的事件只能放弃，记录过位置的时候才说明有事件丢失
+	if e.Seq > c.last+1 {
+		if c.synced {
+			b.reset(c, e.Source)
+		}
+		c.last = e.Seq - 1
+	}
+	// 补齐的事件中已经包含了e
+	if e.Seq <= c.last {
+		return
+	}
+	b.deliver(c, e)
+}
+
+// stale 判断e是否是发布方重启之前的事件，e的epoch更新的时候先按照重启处理，调用方需要持有deliverMu
+func (b *PeerBus) stale(c *busCursor, e InvalidationEvent) bool {
+	if e.Epoch < c.epoch {
+		b.Stats.Stale.Add(1)
+		return true
+	}
+	if e.Epoch > c.epoch {
+		b.restart(c, e.Source, e.Epoch)
+	}
+	return false
+}
+
+// deliver 把紧接着cursor的事件交给订阅者，已经交过的事件丢弃，调用方需要持有deliverMu
+func (b *PeerBus) deliver(c *busCursor, e InvalidationEvent) {
+	if e.Epoch != c.epoch {
+		return
+	}
+	if e.Seq <= c.last {
+		b.Stats.Duplicates.Add(1)
+		return
+	}
+	b.Stats.Received.Add(1)
+	b.subs.deliver(e)
+	c.last, c.synced = e.Seq, true
+}
+
+// apply 按顺序把拉取到的事件交给订阅者，日志中最早的事件和记录过的位置之间有缺口的时候先交给订阅者一个Reset事件
+// 第一次同步的节点和刚重启过的节点直接从日志中最早的事件开始，不会Reset
+func (b *PeerBus) apply(c *busCursor, source string, events *ycachepb.EventLog) {
+	if events.Epoch < c.epoch {
+		return
+	}
+	if events.Epoch > c.epoch {
+		b.restart(c, source, events.Epoch)
+	}
+	first := events.Last + 1
+	if len(events.Events) > 0 {
+		first = events.Events[0].Seq
+	}
+	if events.Last > c.last && first > c.last+1 {
+		if c.synced {
+			b.reset(c, source)
+		}
+		c.last = first - 1
+	}
+	for _, pe := range events.Events {
+		if pe.Seq != c.last+1 {
+			continue
+		}
+		b.Stats.Received.Add(1)
+		b.subs.deliver(eventFromProto(pe))
+		c.last = pe.Seq
+	}
+	c.synced = true
+}
+
+// restart 节点重启之后seq重新从1开始，记录过的epoch变化的时候，旧epoch中没有收到的事件已经无法补齐
+// 第一次见到的节点只记录epoch，当前节点之前没有处理过它的事件，不需要Reset
+func (b *PeerBus) restart(c *busCursor, source string, epoch int64) {
+	if c.epoch != 0 {
+		b.reset(c, source)
+	}
+	c.epoch, c.last, c.synced = epoch, 0, false
+}
+
+// reset 通知订阅者source的事件有丢失
+func (b *PeerBus) reset(c *busCursor, source string) {
+	b.Stats.Resets.Add(1)
+	b.pool.Log("lost invalidations from %s after seq %d", source, c.last)
+	b.subs.deliver(InvalidationEvent{Source: source, Epoch: c.epoch, Reset: true})
+}
+
+// fetch 拉取peer在since之后发布的事件
+func (b *PeerBus) fetch(ctx context.Context, peer string, since uint64) (*ycachepb.EventLog, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+b.pool.basePath+eventsPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.pool.opts.Secret != "" {
+		signRequest(req, b.pool.opts.Secret)
+	}
+	res, err := b.pool.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+	body, err := readBody(res, b.pool.opts.MaxResponseBytes)
+	if err != nil {
+		return nil, err
+	}
+	events := &ycachepb.EventLog{}
+	if err = proto.Unmarshal(body, events); err != nil {
+		return nil, fmt.Errorf("decoding event log: %v", err)
+	}
+	return events, nil
+}
+
+// serveEvent 接收其他节点发布的事件，只接受Set中设置过的节点
+func (b *PeerBus) serveEvent(w http.ResponseWriter, r *http.Request) {
+	e, err := eventFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.pool.mu.Lock()
+	known := false
+	for _, member := range b.pool.members {
+		known = known || member == e.Source
+	}
+	b.pool.mu.Unlock()
+	if !known || e.Source == b.pool.self {
+		http.Error(w, "unknown peer: "+e.Source, http.StatusBadRequest)
+		return
+	}
+	b.receive(e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveLog 返回当前节点在since之后发布、并且还保留着的事件
+func (b *PeerBus) serveLog(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	events := &ycachepb.EventLog{Epoch: b.epoch, Last: b.seq}
+	for _, e := range b.log {
+		if e.Seq > since {
+			events.Events = append(events.Events, eventToProto(e))
+		}
+	}
+	b.mu.Unlock()
+	body, err := proto.Marshal(events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// eventsHandler 处理失效事件的请求，没有注册PeerBus的时候返回404
+func (p *HTTPPool) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	bus := p.bus
+	p.mu.Unlock()
+	if bus == nil {
+		http.Error(w, "no invalidation bus", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		bus.serveLog(w, r)
+	case http.MethodPost:
+		bus.serveEvent(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// eventQuery 把事件编码为query参数，和其他节点间的通知一样，参数可以被签名覆盖到
+func eventQuery(e InvalidationEvent) url.Values {
+	query := url.Values{
+		"source": {e.Source},
+		"epoch":  {strconv.FormatInt(e.Epoch, 10)},
+		"seq":    {strconv.FormatUint(e.Seq, 10)},
+	}
+	if e.Group != "" {
+		query.Set("group", e.Group)
+	}
+	if e.Key != "" {
+		query.Set("key", e.Key)
+	}
+	if e.Tag != "" {
+		query.Set("tag", e.Tag)
+	}
+	return query
+}
+
+// eventFromQuery 解析eventQuery编码的事件
+func eventFromQuery(query url.Values) (InvalidationEvent, error) {
+	e := InvalidationEvent{Source: query.Get("source"), Group: query.Get("group"), Key: query.Get("key"), Tag: query.Get("tag")}
+	var err error
+	if e.Epoch, err = strconv.ParseInt(query.Get("epoch"), 10, 64); err != nil {
+		return e, fmt.Errorf("bad epoch: %v", err)
+	}
+	if e.Seq, err = strconv.ParseUint(query.Get("seq"), 10, 64); err != nil || e.Seq == 0 {
+		return e, fmt.Errorf("bad seq: %q", query.Get("seq"))
+	}
+	if e.Source == "" {
+		return e, fmt.Errorf("source is required")
+	}
+	return e, nil
+}
+
+func eventToProto(e InvalidationEvent) *ycachepb.Event {
+	return &ycachepb.Event{Source: e.Source, Epoch: e.Epoch, Seq: e.Seq, Group: e.Group, Key: e.Key, Tag: e.Tag}
+}
+
+func eventFromProto(pe *ycachepb.Event) InvalidationEvent {
+	return InvalidationEvent{Source: pe.Source, Epoch: pe.Epoch, Seq: pe.Seq, Group: pe.Group, Key: pe.Key, Tag: pe.Tag}
+}
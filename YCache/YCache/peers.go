@@ -21,4 +21,23 @@ type PeerGetter interface {
 	// 基于group、key的信息，实现HTTP的客户端，返回其他节点的缓存记录
 	//Get(group string, key string) ([]byte, error)
 	Get(in *ycachepb.Request, out *ycachepb.Response) error
-}
\ No newline at end of file
+}
+
+// TagInvalidator PeerPicker可以选择实现的接口，用于把按标签失效通知给其他所有节点
+type TagInvalidator interface {
+	// InvalidateTag 通知其他所有节点删除group中带有tag的记录，group为空表示所有group
+	InvalidateTag(group, tag string) error
+}
+
+// FallbackPicker 用于key的所属节点不可用时，选出代为加载数据的备用节点
+// 所有节点选出的备用节点相同，这样同一个key在集群中只会被备用节点加载一次，而不是每个节点都去请求数据源
+type FallbackPicker interface {
+	// PickFallback 返回hash环上所属节点之后的下一个节点，isSelf表示备用节点就是当前节点
+	PickFallback(key string) (peer PeerGetter, isSelf bool, ok bool)
+}
+
+// OwnerPicker PeerPicker可以选择实现的接口，判断key在hash环上是否属于当前节点
+// 失效事件丢失之后，Group据此删除不属于当前节点的key，这些key可能是Set或者备用节点加载写入的旧副本
+type OwnerPicker interface {
+	IsOwner(key string) bool
+}
@@ -0,0 +1,369 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+// Package resp 兼容Redis协议(RESP)的前端服务，现有的Redis客户端和redis-cli可以直接读写YCache
+// key的格式为"group:key"，对应GetGroup(group)中的key
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	YCache "seven-days-projects/YCache/YCache"
+	"seven-days-projects/YCache/YCache/internal/tcpserver"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxArgs         = 1 << 20   // 单个命令最多的参数个数
+	maxBulkSize     = 64 << 20  // 单个参数的最大长度
+	maxCommandBytes = 128 << 20 // 单个命令所有参数的总长度上限
+
+	// initialArgs 参数个数来自客户端，不能据此预先分配，最多预先分配这么多个，之后按需扩容
+	initialArgs = 64
+)
+
+// errProtocol 客户端发送的内容不符合RESP协议，回复错误之后关闭连接
+var errProtocol = errors.New("ERR Protocol error")
+
+// Server Redis协议的前端服务
+type Server struct {
+	tcp *tcpserver.Server
+}
+
+// NewServer 创建Redis协议的前端服务
+func NewServer() *Server {
+	s := &Server{}
+	s.tcp = tcpserver.New("resp", s.serveConn)
+	return s
+}
+
+// ListenAndServe 监听addr并处理请求，阻塞直到出错或者Close
+func (s *Server) ListenAndServe(addr string) error {
+	return s.tcp.ListenAndServe(addr)
+}
+
+// Serve 处理ln上的连接，阻塞直到出错或者Close
+func (s *Server) Serve(ln net.Listener) error {
+	return s.tcp.Serve(ln)
+}
+
+// Shutdown 停止接受新连接，等待正在执行的命令完成之后关闭连接，ctx超时之后直接关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.tcp.Shutdown(ctx)
+}
+
+// Close 关闭所有监听和连接
+func (s *Server) Close() error {
+	return s.tcp.Close()
+}
+
+// serveConn 依次处理一个连接上的命令，支持pipeline
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := &writer{bufio.NewWriter(conn)}
+	for {
+		err := s.tcp.Next(conn, r)
+		var args [][]byte
+		if err == nil {
+			args, err = readCommand(r)
+		}
+		if err != nil {
+			if err == errProtocol {
+				w.writeError(err.Error())
+				w.Flush()
+			} else if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) && !s.tcp.Closed() {
+				log.Println("[YCache] resp read failed:", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := execute(w, args)
+		// 客户端没有更多的命令时才flush，pipeline的多个回复一次写出
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// readCommand 读取一个命令，支持RESP数组和inline两种格式
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		// inline命令，例如telnet中直接输入的PING
+		fields := strings.Fields(string(line))
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errProtocol
+	}
+	capacity := n
+	if capacity > initialArgs {
+		capacity = initialArgs
+	}
+	args := make([][]byte, 0, capacity)
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkSize || total+size > maxCommandBytes {
+			return nil, errProtocol
+		}
+		total += size
+		// 参数后面跟着\r\n
+		arg, err := tcpserver.ReadN(r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine 读取一行，去掉结尾的\r\n
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// execute 执行一个命令并写入回复，返回是否需要关闭连接
+func execute(w *writer, args [][]byte) (quit bool) {
+	cmd := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.writeSimple("PONG")
+		case 1:
+			w.writeBulk(args[0])
+		default:
+			w.writeArity(cmd)
+		}
+	case "GET":
+		if len(args) != 1 {
+			w.writeArity(cmd)
+			return
+		}
+		group, key, err := lookup(args[0])
+		if err != nil {
+			w.writeError(err.Error())
+			return
+		}
+		value, err := get(group, key)
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeBulk(value)
+	case "MGET":
+		if len(args) == 0 {
+			w.writeArity(cmd)
+			return
+		}
+		// 和Redis一样，格式不正确的key返回nil，获取失败的key返回错误，不影响其他key
+		w.writeArray(len(args))
+		for _, arg := range args {
+			group, key, err := lookup(arg)
+			if err != nil {
+				w.writeBulk(nil)
+				continue
+			}
+			value, err := get(group, key)
+			if err != nil {
+				w.writeError("ERR " + err.Error())
+				continue
+			}
+			w.writeBulk(value)
+		}
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			w.writeArity(cmd)
+			return
+		}
+		group, key, err := lookup(args[0])
+		if err != nil {
+			w.writeError(err.Error())
+			return
+		}
+		var ttl time.Duration
+		if len(args) == 4 {
+			if ttl, err = parseTTL(args[2], args[3]); err != nil {
+				w.writeError(err.Error())
+				return
+			}
+		}
+		if err := group.Set(key, args[1], ttl); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeSimple("OK")
+	case "DEL":
+		if len(args) == 0 {
+			w.writeArity(cmd)
+			return
+		}
+		var n int64
+		for _, arg := range args {
+			group, key, err := lookup(arg)
+			if err != nil {
+				continue
+			}
+			if group.Remove(key) {
+				n++
+			}
+		}
+		w.writeInt(n)
+	case "INFO":
+		w.writeBulk([]byte(info()))
+	case "QUIT":
+		w.writeSimple("OK")
+		return true
+	default:
+		w.writeError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+	return
+}
+
+// lookup 把"group:key"拆分为group和key
+func lookup(arg []byte) (*YCache.Group, string, error) {
+	s := string(arg)
+	i := strings.IndexByte(s, ':')
+	if i <= 0 || i == len(s)-1 {
+		return nil, "", errors.New("ERR key must be in the form group:key")
+	}
+	group := YCache.GetGroup(s[:i])
+	if group == nil {
+		return nil, "", fmt.Errorf("ERR no such group '%s'", s[:i])
+	}
+	return group, s[i+1:], nil
+}
+
+// get 获取value，key不存在的时候返回nil，其他错误返回给客户端，不能当作key不存在
+func get(group *YCache.Group, key string) ([]byte, error) {
+	view, err := group.Get(key)
+	if errors.Is(err, YCache.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 不能返回nil，空value和不存在是不同的
+	if b := view.ByteSlice(); b != nil {
+		return b, nil
+	}
+	return []byte{}, nil
+}
+
+// parseTTL 解析SET命令的EX/PX选项
+func parseTTL(option, value []byte) (time.Duration, error) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("ERR invalid expire time in 'set' command")
+	}
+	switch strings.ToUpper(string(option)) {
+	case "EX":
+		return time.Duration(n) * time.Second, nil
+	case "PX":
+		return time.Duration(n) * time.Millisecond, nil
+	}
+	return 0, errors.New("ERR syntax error")
+}
+
+// info INFO命令的内容，每个group一行，格式和Redis的keyspace一致
+func info() string {
+	var b strings.Builder
+	b.WriteString("# YCache\r\n")
+	for _, name := range YCache.GroupNames() {
+		group := YCache.GetGroup(name)
+		b.WriteString(name)
+		b.WriteByte(':')
+		sep := ""
+		group.Stats.Each(func(name string, value int64) {
+			fmt.Fprintf(&b, "%s%s=%d", sep, name, value)
+			sep = ","
+		})
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// writer 按照RESP协议写入回复
+type writer struct {
+	*bufio.Writer
+}
+
+func (w *writer) writeSimple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *writer) writeError(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w *writer) writeArity(cmd string) {
+	w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func (w *writer) writeInt(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// writeBulk b为nil的时候写入nil bulk string
+func (w *writer) writeBulk(b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) writeArray(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
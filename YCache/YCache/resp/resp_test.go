@@ -0,0 +1,191 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	YCache "seven-days-projects/YCache/YCache"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// command 按照RESP数组格式编码命令
+func command(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return s
+}
+
+// readReply 读取一个回复，数组回复的各个元素用空格连接，nil返回"(nil)"
+func readReply(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		if n < 0 {
+			return "(nil)"
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	case '*':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		items := make([]string, n)
+		for i := range items {
+			items[i] = readReply(t, r)
+		}
+		return strings.Join(items, " ")
+	}
+	return line
+}
+
+func TestServer(t *testing.T) {
+	db := map[string]string{"Tom": "630", "Jack": "589"}
+	YCache.NewGroup("resp-scores", 2<<10, YCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		if key == "Broken" {
+			return nil, errors.New("backend unavailable")
+		}
+		return nil, fmt.Errorf("%s: %w", key, YCache.ErrNotFound)
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer()
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{"PING\r\n", "+PONG"},
+		{command("GET", "resp-scores:Tom"), "630"},
+		{command("GET", "resp-scores:Sam"), "(nil)"},
+		{command("GET", "resp-scores:Broken"), "-ERR backend unavailable"},
+		{command("GET", "Tom"), "-ERR key must be in the form group:key"},
+		{command("GET", "unknown:Tom"), "-ERR no such group 'unknown'"},
+		{command("SET", "resp-scores:Sam", "567", "EX", "60"), "+OK"},
+		{command("SET", "resp-scores:Kate", "0", "EX", "0"), "-ERR invalid expire time in 'set' command"},
+		{command("SET", "resp-scores:Big", strings.Repeat("x", 4096)), "-ERR ycache: value too large to cache"},
+		{command("MGET", "resp-scores:Tom", "resp-scores:Sam", "bad", "resp-scores:Broken", "resp-scores:Jack"), "630 567 (nil) -ERR backend unavailable 589"},
+		{command("DEL", "resp-scores:Sam", "resp-scores:Kate"), ":1"},
+		{command("FLUSHALL"), "-ERR unknown command 'flushall'"},
+	}
+	for _, tt := range tests {
+		if _, err := conn.Write([]byte(tt.request)); err != nil {
+			t.Fatal(err)
+		}
+		if got := readReply(t, r); got != tt.want {
+			t.Fatalf("%q: got %q, want %q", tt.request, got, tt.want)
+		}
+	}
+
+	// pipeline中的多个命令按顺序回复
+	conn.Write([]byte(command("GET", "resp-scores:Jack") + command("PING", "hello")))
+	if got := readReply(t, r) + " " + readReply(t, r); got != "589 hello" {
+		t.Fatalf("pipelined replies: got %q", got)
+	}
+
+	conn.Write([]byte(command("INFO")))
+	if got := readReply(t, r); !strings.Contains(got, "resp-scores:gets=") {
+		t.Fatalf("INFO should report group stats, got %q", got)
+	}
+}
+
+// TestNegativeLength 负数的参数个数和参数长度回复协议错误并关闭连接，不会导致进程崩溃
+func TestNegativeLength(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer()
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	for _, request := range []string{"*-1\r\n", "*1\r\n$-2\r\n"} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(request))
+		if got := readReply(t, bufio.NewReader(conn)); got != "-ERR Protocol error" {
+			t.Fatalf("%q: got %q", request, got)
+		}
+		conn.Close()
+	}
+}
+
+// TestLargeBulkLength 声明了很大的参数长度却没有发送数据，不会按照声明的长度分配内存
+func TestLargeBulkLength(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readCommand(bufio.NewReader(strings.NewReader("*1\r\n$" + strconv.Itoa(maxBulkSize) + "\r\nabc")))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+		t.Fatalf("allocated %d bytes for a 3-byte argument", grown)
+	}
+}
+
+// TestIdleTimeout 空闲超过IdleTimeout的连接被关闭
+func TestIdleTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer()
+	srv.tcp.IdleTimeout = 50 * time.Millisecond
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("PING\r\n"))
+	if got := readReply(t, r); got != "+PONG" {
+		t.Fatalf("PING: got %q", got)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("idle connection should be closed, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"math/rand"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLatencyWindow = 100 // 统计最近多少次请求的延迟
+	minLatencySamples    = 20  // 样本少于这个数的时候，不根据p95发送对冲请求
+	maxRetryTokens       = 10  // 重试预算最多积累的次数
+
+	// defaultBudgetRatio 默认的重试预算，重试次数不超过请求次数的10%
+	defaultBudgetRatio = 0.1
+)
+
+// RetryPolicy 请求其他节点的重试策略，Get是幂等的，网络错误和网关错误可以安全的重试
+type RetryPolicy struct {
+	MaxAttempts int           // 最多请求多少次，包括第一次请求
+	BaseDelay   time.Duration // 第一次重试之前等待的时间，之后每次翻倍
+	MaxDelay    time.Duration // 两次重试之间最长等待的时间
+	BudgetRatio float64       // 重试预算，每个请求积累多少次重试机会，例如0.1表示重试次数不超过请求次数的10%，0使用默认值0.1
+	MinRetries  int           // 重试预算的初始值，请求很少的时候也可以重试
+}
+
+// backoff 第attempt次重试之前等待的时间，指数退避，并且加上随机抖动，避免所有节点同时重试
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := r.BaseDelay << uint(attempt)
+	if delay <= 0 || (r.MaxDelay > 0 && delay > r.MaxDelay) {
+		delay = r.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// 在 [delay/2, delay) 之间随机
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryBudget 重试预算，避免节点故障的时候重试把请求量放大数倍
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64, minRetries int) *retryBudget {
+	// ratio为0的时候预算不会恢复，MinRetries用完之后进程再也不会重试
+	if ratio <= 0 {
+		ratio = defaultBudgetRatio
+	}
+	return &retryBudget{ratio: ratio, tokens: float64(minRetries)}
+}
+
+// deposit 每个请求积累ratio次重试机会
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > maxRetryTokens {
+		b.tokens = maxRetryTokens
+	}
+}
+
+// withdraw 消耗一次重试机会，预算不足的时候返回false
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// getWithRetry 按照重试策略请求节点
+func (h *httpGetter) getWithRetry(ctx context.Context, in *ycachepb.Request, out *ycachepb.Response) error {
+	if h.budget != nil {
+		h.budget.deposit()
+	}
+	for attempt := 0; ; attempt++ {
+		retryable, err := h.get(ctx, in, out)
+		if err == nil || !retryable || h.retry == nil || attempt+1 >= h.retry.MaxAttempts {
+			return err
+		}
+		if h.budget != nil && !h.budget.withdraw() {
+			return err
+		}
+		select {
+		case <-time.After(h.retry.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// latencyWindow 统计最近若干次请求的延迟
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int // 下一个样本写入的位置，写满之后覆盖最旧的样本
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+// add 记录一次请求的延迟
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+// percentile 获取延迟的分位数，样本不足的时候ok为false
+func (w *latencyWindow) percentile(p float64) (d time.Duration, ok bool) {
+	w.mu.Lock()
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	w.mu.Unlock()
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*p)], true
+}
+
+// hedgeDelay 发送对冲请求之前等待的时间，0表示不发送对冲请求
+func (p *HTTPPool) hedgeDelay() time.Duration {
+	if p.opts.HedgeDelay > 0 {
+		return p.opts.HedgeDelay
+	}
+	if d, ok := p.latency.percentile(0.95); ok {
+		return d
+	}
+	return 0
+}
+
+// hedgedGetter 对冲请求，所属节点超过delay还没有返回，就向所属节点再发送一次请求，使用先成功返回的结果
+// 只有所属节点保存了key，其他节点没有数据，请求它们只会多加载一次，还会把value缓存在不属于它的节点上
+type hedgedGetter struct {
+	getter *httpGetter
+	delay  time.Duration
+}
+
+// Get 实现PeerGetter接口
+func (h *hedgedGetter) Get(in *ycachepb.Request, out *ycachepb.Response) error {
+	if h.delay <= 0 {
+		return h.getter.Get(in, out)
+	}
+	// 其中一个请求成功之后，取消另一个请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		res *ycachepb.Response
+		err error
+	}
+	results := make(chan result, 2)
+	send := func() {
+		res := &ycachepb.Response{}
+		err := h.getter.getWithRetry(ctx, in, res)
+		results <- result{res, err}
+	}
+	go send()
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	var firstErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Merge(out, r.res)
+				return nil
+			}
+			// key不存在是确定的结果，不需要再等另一个请求
+			if errors.Is(r.err, ErrNotFound) {
+				return r.err
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			// 所属节点直接失败了，交给Group.load的备用节点逻辑处理
+			if pending == 0 {
+				return firstErr
+			}
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				// 第一个请求还占用着原来的连接，第二个请求会使用新的连接，所属节点的singleflight保证只加载一次
+				go send()
+			}
+		}
+	}
+}
+
+// 验证hedgedGetter实现了PeerGetter接口
+var _ PeerGetter = &hedgedGetter{}
@@ -0,0 +1,146 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// valueServer 测试用的节点，前failures次请求返回503，之后返回value
+func valueServer(value string, failures int32, delay time.Duration, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(requests, 1)
+		if n <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(delay)
+		body, _ := proto.Marshal(&ycachepb.Response{Value: []byte(value + r.URL.RawQuery)})
+		w.Write(body)
+	}))
+}
+
+// TestRetry 503之后按照重试策略重试，重试预算用完之后不再重试
+func TestRetry(t *testing.T) {
+	var requests int32
+	srv := valueServer("630", 2, 0, &requests)
+	defer srv.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MinRetries: 2}
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath, retry: policy, budget: newRetryBudget(0, 2)}
+	res := &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "scores", Key: "Tom"}, res); err != nil || string(res.Value) != "630" {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expect 3 requests, got %d", requests)
+	}
+
+	// 预算已经用完，503不再重试
+	atomic.StoreInt32(&requests, -10)
+	if err := getter.Get(&ycachepb.Request{Group: "scores", Key: "Tom"}, &ycachepb.Response{}); err == nil {
+		t.Fatalf("request should fail without retry budget")
+	}
+	if requests != -9 {
+		t.Fatalf("expect no retry, got %d requests", requests+10)
+	}
+}
+
+// TestNotFoundNotRetried 所属节点返回key不存在，请求方得到ErrNotFound，不会重试
+func TestNotFoundNotRetried(t *testing.T) {
+	loads := 0
+	NewGroup("retry-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	retryable, err := getter.get(context.Background(), &ycachepb.Request{Group: "retry-not-found", Key: "Sam"}, &ycachepb.Response{})
+	if !errors.Is(err, ErrNotFound) || retryable || loads != 1 {
+		t.Fatalf("expect ErrNotFound without retry, got %v %v, loads %d", retryable, err, loads)
+	}
+	// group不存在不是key不存在
+	if _, err := getter.get(context.Background(), &ycachepb.Request{Group: "unknown", Key: "Sam"}, &ycachepb.Response{}); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown group should not be reported as ErrNotFound, got %v", err)
+	}
+}
+
+// TestRetryBudgetRefill 默认的重试预算随着请求恢复
+func TestRetryBudgetRefill(t *testing.T) {
+	b := newRetryBudget(0, 0)
+	if b.withdraw() {
+		t.Fatalf("empty budget should not allow retry")
+	}
+	for i := 0; i < 20; i++ {
+		b.deposit()
+	}
+	if !b.withdraw() {
+		t.Fatalf("budget should refill after 20 requests")
+	}
+}
+
+// TestHedgedRequest 所属节点响应慢的时候，再向所属节点发送一次对冲请求，使用先返回的结果
+func TestHedgedRequest(t *testing.T) {
+	var requests int32
+	// 第一个请求很慢，之后的请求立即返回
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		body, _ := proto.Marshal(&ycachepb.Response{Value: []byte("630" + r.URL.RawQuery)})
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	h := &hedgedGetter{
+		getter: &httpGetter{baseURL: srv.URL + defaultBasePath},
+		delay:  20 * time.Millisecond,
+	}
+	start := time.Now()
+	res := &ycachepb.Response{}
+	if err := h.Get(&ycachepb.Request{Group: "scores", Key: "Tom"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "630" {
+		t.Fatalf("expect value from the owner without fallback flag, got %s", res.Value)
+	}
+	if time.Since(start) > 200*time.Millisecond {
+		t.Fatalf("hedged request should not wait for the slow request")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expect 2 requests to the owner, got %d", n)
+	}
+}
+
+// TestLatencyPercentile 样本足够之后才计算p95
+func TestLatencyPercentile(t *testing.T) {
+	w := newLatencyWindow(100)
+	for i := 1; i < minLatencySamples; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := w.percentile(0.95); ok {
+		t.Fatalf("percentile should need enough samples")
+	}
+	for i := minLatencySamples; i <= 200; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	// 只保留最近100个样本：101ms ~ 200ms
+	if d, _ := w.percentile(0.95); d != 195*time.Millisecond {
+		t.Fatalf("expect p95 195ms, got %v", d)
+	}
+}
@@ -6,13 +6,41 @@
 
 package singleflight
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// errGoexit fn调用了runtime.Goexit，等待者收到这个错误
+var errGoexit = errors.New("singleflight: fn called runtime.Goexit")
+
+// PanicError fn发生panic的时候，传递给所有等待者的错误，包含panic的值和调用栈
+type PanicError struct {
+	Value interface{} // panic的值
+	Stack []byte      // 发生panic时的调用栈
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Result DoChan返回的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool // 结果是否被多个调用方共享
+}
 
 // call 代表正在进行中，或已经结束的请求。使用 sync.WaitGroup 锁避免重入
 type call struct {
 	wg  sync.WaitGroup
 	val interface{}
 	err error
+
+	dups  int             // 等待这次请求结果的调用方个数，不包括第一次请求
+	chans []chan<- Result // DoChan的调用方
 }
 
 // Group 是 singleflight 的主数据结构，管理不同 key 的请求(call)
@@ -22,16 +50,21 @@ type Group struct {
 }
 
 // Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// shared表示结果是否被多个调用方共享；如果fn发生了panic，所有的调用方都会以*PanicError再次panic，不会永远阻塞
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	// 如果当前key请求已近存在
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait() // 如果请求正在进行中，则等待
-		return c.val, c.err
+		if e, ok := c.err.(*PanicError); ok {
+			panic(e)
+		}
+		return c.val, c.err, true
 	}
 	// 如果是第一次请求key
 	c := new(call)
@@ -39,13 +72,80 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	g.m[key] = c // 将call写入到m中
 	g.mu.Unlock()
 
-	// 获取fn执行的结果，写入到call对象中
-	c.val, c.err = fn()
-	c.wg.Done() // 第一次请求结束，后续的请求可以直接从c对象中获取到第一次请求结果
+	g.doCall(c, key, fn)
+	if e, ok := c.err.(*PanicError); ok {
+		panic(e)
+	}
+	return c.val, c.err, c.dups > 0
+}
 
+// DoChan 与Do相同，但是不会阻塞，而是返回一个channel，结果准备好之后写入channel，便于调用方使用select实现超时
+// 如果fn发生了panic，Result.Err是*PanicError
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.m, key) // 从map中删除对应的key，以便后续请求可以查询执行fn函数
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
 	g.mu.Unlock()
 
-	return c.val, c.err
-}
\ No newline at end of file
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// doCall 执行fn，无论fn正常返回、panic还是调用runtime.Goexit，都会唤醒所有等待者
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	defer func() {
+		// fn既没有正常返回，也没有panic，说明调用了runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		g.mu.Lock()
+		c.wg.Done() // 第一次请求结束，后续的请求可以直接从c对象中获取到第一次请求结果
+		// 从map中删除对应的key，以便后续请求可以查询执行fn函数，如果调用过Forget，map中可能已经是新的call
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		chans := c.chans
+		shared := c.dups > 0
+		g.mu.Unlock()
+
+		for _, ch := range chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: shared}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					recovered = true
+					c.err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		// 获取fn执行的结果，写入到call对象中
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+}
+
+// Forget 删除正在进行中的key，之后对这个key的调用会重新执行fn，而不是等待之前的请求
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
@@ -0,0 +1,128 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:12
+ * @Function：
+ **/
+
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDo 并发请求同一个key，fn只执行一次
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, _ := g.Do("key", func() (interface{}, error) {
+				calls++
+				<-start
+				return "bar", nil
+			})
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times", calls)
+	}
+}
+
+// TestDoPanic fn发生panic，等待者也会收到panic，而不是永远阻塞，之后的请求可以重新执行
+func TestDoPanic(t *testing.T) {
+	var g Group
+	start := make(chan struct{})
+	waiter := make(chan interface{})
+	go func() {
+		defer func() { waiter <- recover() }()
+		<-start
+		g.Do("key", func() (interface{}, error) { return nil, nil })
+	}()
+
+	func() {
+		defer func() {
+			if _, ok := recover().(*PanicError); !ok {
+				t.Fatalf("caller should panic with *PanicError")
+			}
+		}()
+		g.Do("key", func() (interface{}, error) {
+			close(start)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+
+	select {
+	case r := <-waiter:
+		if _, ok := r.(*PanicError); !ok {
+			t.Fatalf("waiter should panic with *PanicError, got %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter blocked forever")
+	}
+
+	if v, err, _ := g.Do("key", func() (interface{}, error) { return "ok", nil }); v != "ok" || err != nil {
+		t.Fatalf("key should be released after panic")
+	}
+}
+
+// TestDoChan 测试DoChan的结果和shared标记
+func TestDoChan(t *testing.T) {
+	var g Group
+	start := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-start
+		return nil, errors.New("failed")
+	}
+	ch1 := g.DoChan("key", fn)
+	ch2 := g.DoChan("key", fn)
+	select {
+	case <-ch1:
+		t.Fatalf("result should not be ready")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(start)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		res := <-ch
+		if res.Err == nil || !res.Shared {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+
+	res := <-g.DoChan("panic", func() (interface{}, error) { panic("boom") })
+	if _, ok := res.Err.(*PanicError); !ok {
+		t.Fatalf("expect *PanicError, got %v", res.Err)
+	}
+}
+
+// TestForget Forget之后，新的请求会重新执行fn
+func TestForget(t *testing.T) {
+	var g Group
+	start := make(chan struct{})
+	ch := g.DoChan("key", func() (interface{}, error) {
+		<-start
+		return 1, nil
+	})
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Fatalf("Do after Forget = %v, shared %v", v, shared)
+	}
+	close(start)
+	if res := <-ch; res.Val != 1 {
+		t.Fatalf("forgotten call should still finish, got %v", res.Val)
+	}
+}
@@ -0,0 +1,175 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// Sink 接收Group.GetTo获取到的value，由Sink决定是否拷贝、拷贝到哪里
+// 调用方根据自己的需要选择：复用缓冲区、分配新的数组、转换为string或者直接解码为protobuf消息
+// 调用方也可以自己实现Sink，GetTo通过SetBytes交给Sink
+type Sink interface {
+	// SetString 使用s作为value
+	SetString(s string) error
+	// SetBytes 使用b作为value，Sink不能持有b，需要的时候自己拷贝
+	SetBytes(b []byte) error
+	// SetProto 使用m序列化之后的数据作为value
+	SetProto(m proto.Message) error
+}
+
+// viewSetter 包内的Sink实现，直接使用cache中的ByteView，省去SetBytes之前的一次拷贝
+type viewSetter interface {
+	// setView 使用cache中的ByteView作为value，v是只读的
+	setView(v *ByteView) error
+}
+
+// setSinkView 把v交给s，包内的Sink直接使用v，其他Sink通过SetBytes获取一份拷贝，cache中的数据不会被修改
+func setSinkView(s Sink, v *ByteView) error {
+	if vs, ok := s.(viewSetter); ok {
+		return vs.setView(v)
+	}
+	return s.SetBytes(v.ByteSlice())
+}
+
+// StringSink 把value保存到*sp中
+func StringSink(sp *string) Sink {
+	return &stringSink{sp: sp}
+}
+
+type stringSink struct {
+	sp *string
+}
+
+func (s *stringSink) SetString(v string) error {
+	*s.sp = v
+	return nil
+}
+
+func (s *stringSink) SetBytes(b []byte) error {
+	*s.sp = string(b)
+	return nil
+}
+
+func (s *stringSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	*s.sp = string(b)
+	return nil
+}
+
+func (s *stringSink) setView(v *ByteView) error {
+	*s.sp = v.String()
+	return nil
+}
+
+// ByteSliceSink 把value拷贝到*dst中，*dst的容量足够的时候复用底层数组，不再分配内存
+// 适合反复读取的调用方复用同一个缓冲区，*dst原来的内容会被覆盖
+func ByteSliceSink(dst *[]byte) Sink {
+	if dst == nil {
+		panic("nil dst")
+	}
+	return &byteSliceSink{dst: dst}
+}
+
+type byteSliceSink struct {
+	dst *[]byte
+}
+
+func (s *byteSliceSink) SetString(v string) error {
+	*s.dst = append((*s.dst)[:0], v...)
+	return nil
+}
+
+func (s *byteSliceSink) SetBytes(b []byte) error {
+	*s.dst = append((*s.dst)[:0], b...)
+	return nil
+}
+
+func (s *byteSliceSink) SetProto(m proto.Message) error {
+	b, err := proto.MarshalOptions{}.MarshalAppend((*s.dst)[:0], m)
+	if err != nil {
+		return err
+	}
+	*s.dst = b
+	return nil
+}
+
+func (s *byteSliceSink) setView(v *ByteView) error {
+	*s.dst = append((*s.dst)[:0], v.data()...)
+	return nil
+}
+
+// AllocatingByteSliceSink 每次都分配新的数组保存value，调用方独占返回的数组，可以随意修改
+func AllocatingByteSliceSink(dst *[]byte) Sink {
+	if dst == nil {
+		panic("nil dst")
+	}
+	return &allocatingByteSliceSink{dst: dst}
+}
+
+type allocatingByteSliceSink struct {
+	dst *[]byte
+}
+
+func (s *allocatingByteSliceSink) SetString(v string) error {
+	*s.dst = []byte(v)
+	return nil
+}
+
+func (s *allocatingByteSliceSink) SetBytes(b []byte) error {
+	*s.dst = cloneBytes(b)
+	return nil
+}
+
+func (s *allocatingByteSliceSink) SetProto(m proto.Message) error {
+	// Marshal返回的就是新分配的数组，不需要再拷贝
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	*s.dst = b
+	return nil
+}
+
+func (s *allocatingByteSliceSink) setView(v *ByteView) error {
+	*s.dst = v.ByteSlice()
+	return nil
+}
+
+// ProtoSink 把value解码到m中，m原来的内容会被清空
+func ProtoSink(m proto.Message) Sink {
+	return &protoSink{dst: m}
+}
+
+type protoSink struct {
+	dst proto.Message
+}
+
+func (s *protoSink) SetString(v string) error {
+	return proto.Unmarshal([]byte(v), s.dst)
+}
+
+func (s *protoSink) SetBytes(b []byte) error {
+	return proto.Unmarshal(b, s.dst)
+}
+
+func (s *protoSink) SetProto(m proto.Message) error {
+	// m和dst可能是不同的消息类型，序列化之后再解码，和从cache中读取的结果一致
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, s.dst)
+}
+
+func (s *protoSink) setView(v *ByteView) error {
+	// Unmarshal不会引用输入的数组，可以直接使用cache中的数据
+	return proto.Unmarshal(v.data(), s.dst)
+}
@@ -0,0 +1,126 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"bytes"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"testing"
+)
+
+// TestSinks 各种Sink获取到的value相同，ByteSliceSink复用调用方的缓冲区
+func TestSinks(t *testing.T) {
+	want := &ycachepb.Request{Group: "scores", Key: "Tom"}
+	encoded, err := proto.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGroup("sink-scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return proto.Marshal(want)
+	}))
+
+	var s string
+	if err := g.GetTo("Tom", StringSink(&s)); err != nil || s != string(encoded) {
+		t.Fatalf("StringSink = %q, %v", s, err)
+	}
+
+	buf := make([]byte, 0, 64)
+	if err := g.GetTo("Tom", ByteSliceSink(&buf)); err != nil || !bytes.Equal(buf, encoded) {
+		t.Fatalf("ByteSliceSink = %q, %v", buf, err)
+	}
+	if cap(buf) != 64 {
+		t.Errorf("ByteSliceSink reallocated the buffer: cap %d", cap(buf))
+	}
+
+	var b []byte
+	if err := g.GetTo("Tom", AllocatingByteSliceSink(&b)); err != nil || !bytes.Equal(b, encoded) {
+		t.Fatalf("AllocatingByteSliceSink = %q, %v", b, err)
+	}
+	// 修改分配的数组不影响cache
+	b[0] ^= 0xff
+	if view, _ := g.Get("Tom"); !view.EqualBytes(encoded) {
+		t.Fatal("AllocatingByteSliceSink shares memory with the cache")
+	}
+
+	got := &ycachepb.Request{Version: "old"}
+	if err := g.GetTo("Tom", ProtoSink(got)); err != nil || !proto.Equal(got, want) {
+		t.Fatalf("ProtoSink = %v, %v", got, err)
+	}
+
+	if err := ProtoSink(got).SetProto(&ycachepb.Request{Key: "Jack"}); err != nil || got.Key != "Jack" {
+		t.Fatalf("SetProto = %v, %v", got, err)
+	}
+}
+
+// countingSink 包外实现的Sink，只能通过导出的方法获取value
+type countingSink struct {
+	b     []byte
+	calls int
+}
+
+func (s *countingSink) SetString(v string) error {
+	return s.SetBytes([]byte(v))
+}
+
+func (s *countingSink) SetBytes(b []byte) error {
+	s.b = b
+	s.calls++
+	return nil
+}
+
+func (s *countingSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.SetBytes(b)
+}
+
+// TestCustomSink 调用方实现的Sink通过SetBytes获取value，持有的数组和cache不共享
+func TestCustomSink(t *testing.T) {
+	g := NewGroup("sink-custom", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}))
+	s := &countingSink{}
+	if err := g.GetTo("Tom", s); err != nil || string(s.b) != "630" || s.calls != 1 {
+		t.Fatalf("GetTo = %q, %d calls, %v", s.b, s.calls, err)
+	}
+	s.b[0] = '7'
+	if view, _ := g.Get("Tom"); !view.EqualString("630") {
+		t.Fatal("custom Sink shares memory with the cache")
+	}
+}
+
+// TestByteViewAccessors ByteView的只读方法
+func TestByteViewAccessors(t *testing.T) {
+	v := &ByteView{b: []byte("hello world")}
+	if v.At(4) != 'o' {
+		t.Errorf("At(4) = %q", v.At(4))
+	}
+	if s := v.Slice(6, 11); !s.EqualString("world") || !s.Equal(&ByteView{b: []byte("world")}) {
+		t.Errorf("Slice(6, 11) = %q", s.String())
+	}
+	dest := make([]byte, 5)
+	if n := v.Copy(dest); n != 5 || string(dest) != "hello" {
+		t.Errorf("Copy = %d, %q", n, dest)
+	}
+	if b, err := ioutil.ReadAll(v.Reader()); err != nil || string(b) != "hello world" {
+		t.Errorf("Reader = %q, %v", b, err)
+	}
+	var w bytes.Buffer
+	if n, err := v.WriteTo(&w); err != nil || n != 11 || w.String() != "hello world" {
+		t.Errorf("WriteTo = %d, %v, %q", n, err, w.String())
+	}
+
+	// 压缩存储的value透明解压
+	z := (&ByteView{b: bytes.Repeat([]byte("a"), 1024)}).compressed()
+	if !z.z || z.At(1023) != 'a' || !z.EqualBytes(bytes.Repeat([]byte("a"), 1024)) {
+		t.Error("accessors on compressed view failed")
+	}
+}
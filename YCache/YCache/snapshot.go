@@ -0,0 +1,142 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"log"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"time"
+)
+
+const (
+	snapshotMagic   = "YCSNAP" // 快照文件的魔数
+	snapshotVersion = 1        // 快照格式的版本号
+
+	// maxSnapshotMessage 快照中单条消息的长度上限，长度前缀超过上限说明文件已经损坏
+	maxSnapshotMessage = 1 << 30
+)
+
+// Snapshot 将mainCache中的记录写入w
+// 格式为：魔数 + 长度前缀的SnapshotHeader + 若干个长度前缀的SnapshotEntry，记录按照从最久未被访问到最近被访问的顺序写入
+func (g *Group) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	header := &ycachepb.SnapshotHeader{
+		Version: snapshotVersion,
+		Group:   g.name,
+		Created: time.Now().UnixNano(),
+	}
+	if err := writeDelimited(bw, header); err != nil {
+		return err
+	}
+
+	// 拷贝一份记录之后再写入，避免写入的时候长时间持有cacheInstance的锁
+	keys, values := g.mainCache.entries()
+	for i, key := range keys {
+		// 解压失败的记录已经损坏，不写入快照
+		value, err := values[i].decompressed()
+		if err != nil {
+			log.Printf("[YCache] %s/%s decompress failed, skipped: %v", g.name, key, err)
+			continue
+		}
+		entry := &ycachepb.SnapshotEntry{Key: key, Value: value.b, Version: value.v, Tags: value.Tags(), ContentType: value.ContentType(), Flags: value.Flags()}
+		if e := value.Expire(); !e.IsZero() {
+			entry.Expire = e.UnixNano()
+		}
+		if err := writeDelimited(bw, entry); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore 从r中读取Snapshot写入的记录，按照原来的访问顺序写入mainCache，已经过期的记录会被跳过
+func (g *Group) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("reading snapshot magic: %v", err)
+	}
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("not a YCache snapshot")
+	}
+	header := &ycachepb.SnapshotHeader{}
+	if err := readDelimited(br, header); err != nil {
+		return fmt.Errorf("reading snapshot header: %v", err)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", header.Version)
+	}
+	if header.Group != g.name {
+		return fmt.Errorf("snapshot of group %s can not be restored to group %s", header.Group, g.name)
+	}
+
+	now := time.Now()
+	for {
+		entry := &ycachepb.SnapshotEntry{}
+		err := readDelimited(br, entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading snapshot entry: %v", err)
+		}
+		value := &ByteView{b: entry.Value, v: entry.Version}
+		if entry.Expire != 0 {
+			value.e = time.Unix(0, entry.Expire)
+		}
+		if len(entry.Tags) > 0 || entry.ContentType != "" || entry.Flags != 0 {
+			value.o = &LoadOptions{Tags: entry.Tags, ContentType: entry.ContentType, Flags: entry.Flags}
+		}
+		if value.expired(now) {
+			continue
+		}
+		g.populateCache(entry.Key, value)
+	}
+}
+
+// writeDelimited 写入varint长度前缀和protobuf编码的消息
+func writeDelimited(w io.Writer, m proto.Message) error {
+	body, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(body)))
+	if _, err = w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// readDelimited 读取writeDelimited写入的消息，没有更多消息的时候返回io.EOF
+func readDelimited(r *bufio.Reader, m proto.Message) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > maxSnapshotMessage {
+		return fmt.Errorf("message too large: %d bytes", size)
+	}
+	// 按实际读到的数据分配内存，文件被截断的时候不会按照长度前缀分配
+	body, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return err
+	}
+	if uint64(len(body)) < size {
+		return io.ErrUnexpectedEOF
+	}
+	return proto.Unmarshal(body, m)
+}
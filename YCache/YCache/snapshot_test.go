@@ -0,0 +1,71 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSnapshotRestore 快照恢复后，记录的值、过期时间和访问顺序保持不变
+func TestSnapshotRestore(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	})
+	g := NewGroup("snapshot", 2<<10, getter)
+	for _, k := range []string{"k1", "k2", "k3"} {
+		g.Get(k)
+	}
+	g.Get("k1")
+	expire := time.Now().Add(time.Hour)
+	g.mainCache.Add("k4", &ByteView{b: []byte("v-k4"), e: expire})
+	g.mainCache.Add("k5", &ByteView{b: []byte("v-k5"), e: time.Now().Add(-time.Second)})
+
+	var buf bytes.Buffer
+	if err := g.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewGroup("snapshot", 2<<10, getter)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	keys, values := restored.mainCache.entries()
+	if expect := []string{"k2", "k3", "k1", "k4"}; !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("expect keys %v, got %v", expect, keys)
+	}
+	if values[3].String() != "v-k4" || !values[3].Expire().Equal(expire) {
+		t.Fatalf("k4 restored incorrectly")
+	}
+
+	other := NewGroup("other", 2<<10, getter)
+	var buf2 bytes.Buffer
+	g.Snapshot(&buf2)
+	if err := other.Restore(&buf2); err == nil {
+		t.Fatalf("restore snapshot of another group should fail")
+	}
+}
+
+// TestRestoreCorrupt 长度前缀损坏或者文件被截断的时候返回错误，不会按照长度前缀分配内存
+func TestRestoreCorrupt(t *testing.T) {
+	g := NewGroup("snapshot-corrupt", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	for _, size := range []uint64{1 << 40, 1 << 20} {
+		var buf bytes.Buffer
+		buf.WriteString(snapshotMagic)
+		var prefix [binary.MaxVarintLen64]byte
+		buf.Write(prefix[:binary.PutUvarint(prefix[:], size)])
+		buf.WriteString("truncated")
+		if err := g.Restore(&buf); err == nil {
+			t.Fatalf("restore corrupt snapshot with length %d should fail", size)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AtomicInt 并发安全的计数器
+type AtomicInt int64
+
+// Add 计数器加n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 获取计数器的值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats Group的统计信息
+type Stats struct {
+	Gets            AtomicInt // 所有Get请求，包括来自其他节点的请求
+	CacheHits       AtomicInt // 命中mainCache的次数
+	Loads           AtomicInt // cache miss之后执行load的次数
+	PeerLoads       AtomicInt // 从其他节点获取成功的次数
+	PeerErrors      AtomicInt // 从其他节点获取失败的次数
+	LocalLoads      AtomicInt // 从Getter获取成功的次数
+	LocalLoadErrs   AtomicInt // 从Getter获取失败的次数
+	RejectedEntries AtomicInt // 超过单条记录大小上限，没有写入cache的次数
+	HotHits         AtomicInt // 命中hotCache的次数
+	PeerNotModified AtomicInt // 其他节点返回value没有变化，只刷新了过期时间的次数
+	CorruptEntries  AtomicInt // cache中的value解压失败，被删除之后重新加载的次数
+
+	UncompressedBytes AtomicInt // 压缩存储的value压缩之前的总长度
+	CompressedBytes   AtomicInt // 压缩存储的value压缩之后的总长度
+}
+
+// Each 按照固定顺序遍历所有计数器，name是下划线风格的名称，供各种协议的stats命令输出
+func (s *Stats) Each(fn func(name string, value int64)) {
+	fn("gets", s.Gets.Get())
+	fn("cache_hits", s.CacheHits.Get())
+	fn("loads", s.Loads.Get())
+	fn("peer_loads", s.PeerLoads.Get())
+	fn("peer_errors", s.PeerErrors.Get())
+	fn("local_loads", s.LocalLoads.Get())
+	fn("local_load_errs", s.LocalLoadErrs.Get())
+	fn("rejected_entries", s.RejectedEntries.Get())
+	fn("hot_hits", s.HotHits.Get())
+	fn("peer_not_modified", s.PeerNotModified.Get())
+	fn("corrupt_entries", s.CorruptEntries.Get())
+	fn("uncompressed_bytes", s.UncompressedBytes.Get())
+	fn("compressed_bytes", s.CompressedBytes.Get())
+}
+
+// CompressionRatio 压缩率，压缩之后的长度除以压缩之前的长度，没有压缩过的时候返回1
+func (s *Stats) CompressionRatio() float64 {
+	uncompressed := s.UncompressedBytes.Get()
+	if uncompressed == 0 {
+		return 1
+	}
+	return float64(s.CompressedBytes.Get()) / float64(uncompressed)
+}
+
+// qpsCounter 统计最近一分钟的QPS，每过一分钟根据Gets的增量重新计算一次
+type qpsCounter struct {
+	mu        sync.Mutex
+	start     time.Time // 当前统计窗口的开始时间
+	startGets int64     // 当前统计窗口开始时的Gets
+	rate      float64   // 上一个统计窗口的QPS
+}
+
+// get 获取最近一分钟的QPS，gets是当前的Gets计数
+func (q *qpsCounter) get(gets int64) float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if q.start.IsZero() {
+		q.start, q.startGets = now, gets
+		return 0
+	}
+	if elapsed := now.Sub(q.start); elapsed >= time.Minute {
+		q.rate = float64(gets-q.startGets) / elapsed.Seconds()
+		q.start, q.startGets = now, gets
+	}
+	return q.rate
+}
@@ -0,0 +1,110 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"strconv"
+)
+
+const (
+	// defaultStreamThreshold value达到这个大小的时候使用流式响应
+	defaultStreamThreshold = 1 << 20
+
+	// streamChunkSize 流式响应每次写入的大小
+	streamChunkSize = 64 << 10
+
+	// streamHeader 流式响应的标记，流式响应的第一个字段一定是value
+	streamHeader = "X-YCache-Stream"
+
+	// defaultMaxResponseBytes 默认的响应体最大长度
+	defaultMaxResponseBytes = 64 << 20
+)
+
+// errResponseTooLarge 响应体超过了长度上限，重新请求也不会变小，不应该重试
+var errResponseTooLarge = errors.New("response too large")
+
+// writeStreamed 流式写入响应，不会把整个value序列化到新的内存中
+// 写入的内容和proto.Marshal的结果兼容：先手动写入value字段的tag和长度，再分块写入value，最后写入其他字段
+func writeStreamed(w http.ResponseWriter, value []byte, rest *ycachepb.Response) error {
+	tail, err := proto.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	header := protowire.AppendTag(nil, 1, protowire.BytesType)
+	header = protowire.AppendVarint(header, uint64(len(value)))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(header)+len(value)+len(tail)))
+	w.Header().Set(streamHeader, "1")
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	for len(value) > 0 {
+		n := len(value)
+		if n > streamChunkSize {
+			n = streamChunkSize
+		}
+		if _, err = w.Write(value[:n]); err != nil {
+			return err
+		}
+		value = value[n:]
+	}
+	_, err = w.Write(tail)
+	return err
+}
+
+// decodeStreamed 解析流式响应，value直接引用body，不会再拷贝一份
+func decodeStreamed(body []byte, out *ycachepb.Response) error {
+	num, typ, n := protowire.ConsumeTag(body)
+	if n < 0 || num != 1 || typ != protowire.BytesType {
+		return fmt.Errorf("streamed response does not start with value")
+	}
+	value, m := protowire.ConsumeBytes(body[n:])
+	if m < 0 {
+		return protowire.ParseError(m)
+	}
+	if err := proto.Unmarshal(body[n+m:], out); err != nil {
+		return err
+	}
+	out.Value = value
+	return nil
+}
+
+// readBody 读取响应体，maxBytes是响应体的最大长度，不大于0的时候使用默认值
+// 知道长度的时候预先分配好内存，长度已经确认不超过maxBytes，异常节点声明的长度不会导致分配过多的内存
+func readBody(res *http.Response, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxResponseBytes
+	}
+	if res.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", errResponseTooLarge, res.ContentLength)
+	}
+	if res.ContentLength >= 0 {
+		body := make([]byte, res.ContentLength)
+		if _, err := io.ReadFull(res.Body, body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+	// 不知道长度，最多多读一个字节，用来判断是否超过了上限
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", errResponseTooLarge, maxBytes)
+	}
+	return body, nil
+}
@@ -0,0 +1,163 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStreamedResponse 大value使用流式响应，结果和proto.Marshal兼容，并且受最大响应长度限制
+func TestStreamedResponse(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 200<<10)
+	NewGroup("stream", 8<<20, GetterFunc(func(key string) ([]byte, error) {
+		return big, nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	res := &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "stream", Key: "big"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Value, big) {
+		t.Fatalf("streamed value corrupted")
+	}
+
+	// 流式写入的内容可以直接用proto.Unmarshal解码，兼容旧版本的节点
+	rec := httptest.NewRecorder()
+	if err := writeStreamed(rec, big, &ycachepb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	old := &ycachepb.Response{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), old); err != nil || !bytes.Equal(old.Value, big) {
+		t.Fatalf("streamed body should be a valid protobuf message: %v", err)
+	}
+
+	// 超过上限的响应重试也不会成功
+	limited := &httpGetter{baseURL: srv.URL + defaultBasePath, maxResponseBytes: 1 << 20}
+	retryable, err := limited.get(context.Background(), &ycachepb.Request{Group: "stream", Key: "big"}, &ycachepb.Response{})
+	if !errors.Is(err, errResponseTooLarge) || retryable {
+		t.Fatalf("response larger than MaxResponseBytes should be rejected without retry: %v %v", retryable, err)
+	}
+}
+
+// TestReadBodyLimit 没有设置上限的时候使用默认上限，不会按照异常节点声明的长度分配内存
+func TestReadBodyLimit(t *testing.T) {
+	res := &http.Response{ContentLength: 1 << 40, Body: io.NopCloser(strings.NewReader("short"))}
+	if _, err := readBody(res, 0); !errors.Is(err, errResponseTooLarge) {
+		t.Fatalf("huge Content-Length should be rejected by the default limit, got %v", err)
+	}
+	res = &http.Response{ContentLength: -1, Body: io.NopCloser(strings.NewReader("0123456789"))}
+	if _, err := readBody(res, 5); !errors.Is(err, errResponseTooLarge) {
+		t.Fatalf("body without Content-Length should be limited, got %v", err)
+	}
+}
+
+// TestResponseMetadata 响应中带上过期时间，允许过期数据的请求直接返回cache中过期的value
+func TestResponseMetadata(t *testing.T) {
+	loads := 0
+	g := NewGroup("metadata", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("fresh"), nil
+	}))
+	expire := time.Now().Add(time.Hour)
+	g.mainCache.Add("Tom", &ByteView{b: []byte("630"), e: expire})
+	g.mainCache.Add("Jack", &ByteView{b: []byte("stale"), e: time.Now().Add(-time.Second)})
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	res := &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "metadata", Key: "Tom"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if view := viewFromResponse(res); !view.Expire().Equal(expire) {
+		t.Fatalf("remote expiry should be honored, got %v", view.Expire())
+	}
+
+	res = &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "metadata", Key: "Jack", AllowStale: true}, res); err != nil || string(res.Value) != "stale" || loads != 0 {
+		t.Fatalf("allow_stale should return the expired value, got %s", res.Value)
+	}
+	res = &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "metadata", Key: "Jack"}, res); err != nil || string(res.Value) != "fresh" || loads != 1 {
+		t.Fatalf("expired value should be reloaded, got %s", res.Value)
+	}
+}
+
+// rewritePeer 把请求转发到另一个group，模拟其他节点上同名的group
+type rewritePeer struct {
+	group  string
+	getter PeerGetter
+}
+
+func (p *rewritePeer) Get(in *ycachepb.Request, out *ycachepb.Response) error {
+	req := proto.Clone(in).(*ycachepb.Request)
+	req.Group = p.group
+	return p.getter.Get(req, out)
+}
+
+func (p *rewritePeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+// TestConditionalFetch hotCache中的副本过期之后发起条件请求，value没有变化时只刷新过期时间
+func TestConditionalFetch(t *testing.T) {
+	NewGroup("conditional", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	res := &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "conditional", Key: "Tom"}, res); err != nil || res.Version == "" {
+		t.Fatalf("response should carry a version, got %q %v", res.Version, err)
+	}
+	version := res.Version
+	res = &ycachepb.Response{}
+	if err := getter.Get(&ycachepb.Request{Group: "conditional", Key: "Tom", Version: version}, res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.NotModified || res.Version != version || len(res.Value) != 0 {
+		t.Fatalf("matching version should get 304 without value, got %+v", res)
+	}
+
+	g := NewGroup("conditional-client", 0, GetterFunc(func(key string) ([]byte, error) {
+		t.Fatalf("key should be loaded from peer")
+		return nil, nil
+	}))
+	g.RegisterPeers(&rewritePeer{group: "conditional", getter: getter})
+	g.SetHotCache(2<<10, time.Hour)
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("failed to get value from peer: %v", err)
+	}
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" || g.Stats.HotHits.Get() != 1 {
+		t.Fatalf("second get should hit hotCache")
+	}
+
+	// 让副本过期，再次获取时发起条件请求
+	stale, _ := g.hotCache.peek("Tom")
+	stale.e = time.Now().Add(-time.Second)
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" || g.Stats.PeerNotModified.Get() != 1 {
+		t.Fatalf("expired copy should be revalidated, got %v", err)
+	}
+	if fresh, _ := g.hotCache.peek("Tom"); fresh.expired(time.Now()) {
+		t.Fatalf("revalidated copy should get a new expiry")
+	}
+}
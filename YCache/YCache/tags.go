@@ -0,0 +1,111 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// tagsPath 按标签失效的路由，在basePath之下，group不能使用这个名称
+	tagsPath = "_tags"
+
+	// defaultBroadcastTimeout 通知其他节点失效的超时时间
+	defaultBroadcastTimeout = 5 * time.Second
+)
+
+// InvalidateTag 删除当前节点中所有带有tag的记录，包括hotCache中的副本，并通知其他节点同样删除
+// 设置了失效事件总线的时候通过总线通知，否则通过PeerPicker通知
+// 返回当前节点删除的记录数，通知失败的时候返回第一个错误，当前节点的删除不受影响
+func (g *Group) InvalidateTag(tag string) (int, error) {
+	n := g.invalidateTag(tag)
+	if g.bus != nil {
+		return n, g.bus.Publish(InvalidationEvent{Group: g.name, Tag: tag})
+	}
+	if peers, ok := g.peers.(TagInvalidator); ok {
+		return n, peers.InvalidateTag(g.name, tag)
+	}
+	return n, nil
+}
+
+// invalidateTag 删除当前节点中所有带有tag的记录
+func (g *Group) invalidateTag(tag string) int {
+	return g.mainCache.removeTag(tag) + g.hotCache.removeTag(tag)
+}
+
+// InvalidateTag 删除所有group中带有tag的记录，并通知其他节点同样删除，适合同一个标签分布在多个group的情况
+// 每个失效事件总线或者PeerPicker只通知一次，返回当前节点删除的记录数和第一个通知失败的错误
+func InvalidateTag(tag string) (int, error) {
+	n := invalidateTagLocally(tag)
+	notified := make(map[interface{}]bool)
+	var first error
+	for _, name := range GroupNames() {
+		g := GetGroup(name)
+		var err error
+		if g.bus != nil {
+			if notified[g.bus] {
+				continue
+			}
+			notified[g.bus] = true
+			err = g.bus.Publish(InvalidationEvent{Tag: tag})
+		} else if peers, ok := g.peers.(TagInvalidator); ok && !notified[peers] {
+			notified[peers] = true
+			err = peers.InvalidateTag("", tag)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return n, first
+}
+
+// invalidateTagLocally 删除当前节点所有group中带有tag的记录
+func invalidateTagLocally(tag string) int {
+	n := 0
+	for _, name := range GroupNames() {
+		n += GetGroup(name).invalidateTag(tag)
+	}
+	return n
+}
+
+// InvalidateTag 实现TagInvalidator接口，并发通知其他所有节点删除group中带有tag的记录
+func (p *HTTPPool) InvalidateTag(group, tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultBroadcastTimeout)
+	defer cancel()
+	return p.broadcast("invalidate tag "+tag, func(peer string) error {
+		return p.post(ctx, peer, tagsPath, url.Values{"group": {group}, "tag": {tag}})
+	})
+}
+
+// tagsHandler 处理其他节点的按标签失效通知，只删除当前节点的记录，不再继续通知
+func (p *HTTPPool) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	tag := query.Get("tag")
+	if tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+	var n int
+	if name := query.Get("group"); name == "" {
+		n = invalidateTagLocally(tag)
+	} else if group := GetGroup(name); group != nil {
+		n = group.invalidateTag(tag)
+	} else {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	p.Log("invalidated %d entries with tag %s", n, tag)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestInvalidateTag 按标签删除当前节点的记录，并通过HTTPPool通知其他节点
+func TestInvalidateTag(t *testing.T) {
+	loads := 0
+	g := NewGroup("tags", 2<<10, LoaderFunc(func(key string) ([]byte, LoadOptions, error) {
+		loads++
+		if key == "profile" || key == "orders" {
+			return []byte(key), LoadOptions{Tags: []string{"user:1"}}, nil
+		}
+		return []byte(key), LoadOptions{Tags: []string{"user:2"}}, nil
+	}))
+	for _, key := range []string{"profile", "orders", "other"} {
+		g.Get(key)
+	}
+	if n, err := g.InvalidateTag("user:1"); err != nil || n != 2 {
+		t.Fatalf("InvalidateTag = %d, %v", n, err)
+	}
+	for _, key := range []string{"profile", "orders", "other"} {
+		g.Get(key)
+	}
+	if loads != 5 {
+		t.Fatalf("only tagged keys should be reloaded, loads = %d", loads)
+	}
+
+	// A通知B，B删除自己的记录，进程内的group是共享的，因此可以直接检查g
+	var poolB *HTTPPool
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { poolB.ServeHTTP(w, r) }))
+	defer srvB.Close()
+	poolA, _ := NewHTTPPoolOpts("http://self.invalid", &HTTPPoolOptions{Secret: "s3cret"})
+	poolB, _ = NewHTTPPoolOpts(srvB.URL, &HTTPPoolOptions{Secret: "s3cret"})
+	poolA.Set("http://self.invalid", srvB.URL)
+
+	if err := poolA.InvalidateTag("tags", "user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := g.mainCache.cache.KeysByTag("user:2"); len(keys) != 0 {
+		t.Fatalf("peer should have removed %v", keys)
+	}
+	if err := poolA.InvalidateTag("", "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := g.mainCache.cache.KeysByTag("user:1"); len(keys) != 0 {
+		t.Fatalf("peer should have removed %v from all groups", keys)
+	}
+	if err := poolA.InvalidateTag("no-such-group", "user:1"); err == nil {
+		t.Fatalf("unknown group should be reported")
+	}
+}
@@ -0,0 +1,83 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"fmt"
+	"time"
+)
+
+// TypedGetter 当cache miss的时候，获取T类型的数据
+type TypedGetter[T any] interface {
+	Get(key string) (T, error)
+}
+
+// TypedGetterFunc 函数类型，实现TypedGetter接口
+type TypedGetterFunc[T any] func(key string) (T, error)
+
+// Get 实现TypedGetter接口的Get方法
+func (f TypedGetterFunc[T]) Get(key string) (T, error) {
+	return f(key)
+}
+
+// TypedGroup 保存T类型value的Group，底层仍然是一个普通的Group，
+// value使用Codec编码之后保存，节点间通信和其他功能都和Group一样
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// NewTypedGroup 创建TypedGroup，同时以name注册一个普通的Group，GetGroup(name)可以获取到底层的Group
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec[T], getter TypedGetter[T]) *TypedGroup[T] {
+	if codec == nil {
+		panic("nil Codec")
+	}
+	if getter == nil {
+		panic("nil Getter")
+	}
+	g := NewGroup(name, cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		v, err := getter.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	}))
+	return &TypedGroup[T]{group: g, codec: codec}
+}
+
+// Group 获取底层的Group，用于注册节点、查看统计信息等
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+// Get 获取key对应的value，每次都从缓存的字节解码出一个新的T，调用方可以随意修改
+func (t *TypedGroup[T]) Get(key string) (T, error) {
+	view, err := t.group.Get(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	v, err := t.codec.Unmarshal(view.data())
+	if err != nil {
+		return v, fmt.Errorf("decode %s/%s: %v", t.group.name, key, err)
+	}
+	return v, nil
+}
+
+// Set 编码之后直接写入当前节点的cache，ttl为0表示不过期
+func (t *TypedGroup[T]) Set(key string, v T, ttl time.Duration) error {
+	b, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.group.Set(key, b, ttl)
+}
+
+// Remove 从当前节点的cache中删除记录，返回记录是否存在
+func (t *TypedGroup[T]) Remove(key string) bool {
+	return t.group.Remove(key)
+}
@@ -0,0 +1,158 @@
+/**
+ * @Author：Robby
+ * @Date：2022/1/9 02:06
+ * @Function：
+ **/
+
+package YCache
+
+import (
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"seven-days-projects/YCache/YCache/ycachepb"
+	"testing"
+	"time"
+)
+
+type typedScore struct {
+	Name    string
+	Score   int
+	Ratio   float64
+	Passed  bool
+	Tags    []string
+	Extra   map[string]int
+	Ranks   map[int]string
+	Updated time.Time
+	Raw     []byte
+}
+
+func newTypedScore() typedScore {
+	return typedScore{
+		Name:    "Tom",
+		Score:   630,
+		Ratio:   0.75,
+		Passed:  true,
+		Tags:    []string{"a", "b"},
+		Extra:   map[string]int{"math": 150, "english": -3},
+		Ranks:   map[int]string{1: "Tom", -2: "Jack"},
+		Updated: time.Date(2022, 1, 9, 2, 6, 0, 0, time.UTC),
+		Raw:     []byte{0, 1, 2},
+	}
+}
+
+// TestCodecs 各种Codec编码之后能够解码出相同的值
+func TestCodecs(t *testing.T) {
+	codecs := map[string]Codec[typedScore]{
+		"json":    JSONCodec[typedScore]{},
+		"gob":     GobCodec[typedScore]{},
+		"msgpack": MsgpackCodec[typedScore]{},
+	}
+	want := newTypedScore()
+	for name, codec := range codecs {
+		b, err := codec.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s marshal: %v", name, err)
+		}
+		got, err := codec.Unmarshal(b)
+		if err != nil {
+			t.Fatalf("%s unmarshal: %v", name, err)
+		}
+		if !got.Updated.Equal(want.Updated) {
+			t.Errorf("%s: Updated = %v, want %v", name, got.Updated, want.Updated)
+		}
+		got.Updated = want.Updated
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	// 不支持的key在编码的时候就返回错误，而不是每次解码失败
+	if _, err := (MsgpackCodec[map[[2]int]string]{}).Marshal(map[[2]int]string{{1, 2}: "Tom"}); err == nil {
+		t.Errorf("msgpack: map with array keys should not be encoded")
+	}
+
+	// key按照原来的类型解码，整数1和字符串"1"不会混在一起
+	b, err := msgpackMarshal(map[string]int{"1": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranks map[int]int
+	if err := msgpackUnmarshal(b, &ranks); err == nil {
+		t.Errorf("msgpack: string key decoded into int key: %v", ranks)
+	}
+	if b, err = msgpackMarshal(map[int]string{1: "int"}); err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := msgpackUnmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[interface{}]interface{}{int64(1): "int"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("msgpack: got %#v, want %#v", got, want)
+	}
+	// bin格式的key按原样解码为字符串
+	if err := msgpackUnmarshal([]byte{0x81, 0xc4, 0x02, 'h', 'i', 0x01}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"hi": int64(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("msgpack: got %#v, want %#v", got, want)
+	}
+
+	in := &ycachepb.Request{Group: "scores", Key: "Tom", Version: "v1"}
+	var pc Codec[*ycachepb.Request] = ProtoCodec[*ycachepb.Request]{}
+	b, err = pc.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := pc.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(in, out) {
+		t.Errorf("proto: got %v, want %v", out, in)
+	}
+}
+
+// TestTypedGroup 命中缓存之后不再调用Getter，每次Get都返回独立的值
+func TestTypedGroup(t *testing.T) {
+	loads := 0
+	g := NewTypedGroup[typedScore]("typed-scores", 2<<10, MsgpackCodec[typedScore]{}, TypedGetterFunc[typedScore](func(key string) (typedScore, error) {
+		loads++
+		v := newTypedScore()
+		v.Name = key
+		return v, nil
+	}))
+	if GetGroup("typed-scores") != g.Group() {
+		t.Fatal("typed group is not registered")
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := g.Get("Jack")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Name != "Jack" || v.Extra["math"] != 150 {
+			t.Fatalf("got %+v", v)
+		}
+		// 修改返回值不影响缓存
+		v.Extra["math"] = 0
+	}
+	if loads != 1 {
+		t.Fatalf("getter called %d times, want 1", loads)
+	}
+
+	v := newTypedScore()
+	v.Score = 1
+	if err := g.Set("Sam", v, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := g.Get("Sam"); err != nil || got.Score != 1 {
+		t.Fatalf("Get(Sam) = %+v, %v", got, err)
+	}
+	if !g.Remove("Sam") {
+		t.Fatal("Remove(Sam) = false")
+	}
+	if got, _ := g.Get("Sam"); got.Name != "Sam" || loads != 2 {
+		t.Fatalf("Get(Sam) after Remove = %+v, loads %d", got, loads)
+	}
+}
@@ -7,13 +7,27 @@
 package YCache
 
 import (
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"seven-days-projects/YCache/YCache/diskcache"
+	"seven-days-projects/YCache/YCache/lru"
 	"seven-days-projects/YCache/YCache/singleflight"
 	"seven-days-projects/YCache/YCache/ycachepb"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// ErrNotFound Getter在数据源中找不到key的时候可以返回(或者包装)这个错误，前端服务据此返回"不存在"而不是服务端错误
+var ErrNotFound = errors.New("ycache: key not found")
+
+// ErrTooLarge value超过单条记录大小上限，没有写入cache，见SetMaxEntryBytes
+var ErrTooLarge = errors.New("ycache: value too large to cache")
+
 // Getter 当cache miss的时候，从哪里获取数据
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -37,6 +51,27 @@ type Group struct {
 	peers PeerPicker // PeerPicker接口的实现体是HTTPPool
 
 	loader *singleflight.Group // 这里是singleflight的Group
+
+	maxEntryBytes int64 // 单条记录最大内存，0表示以cacheBytes为上限
+	compressBytes int   // value长度达到这个值的时候压缩存储，0表示不压缩
+	Stats         Stats // 统计信息
+
+	qps qpsCounter // 最近一分钟的QPS
+
+	hotCache  cacheInstance                          // 从其他节点获取的value的本地副本
+	hotTTL    time.Duration                          // hotCache中副本的有效期，0表示不使用hotCache
+	versionFn func(key string, value []byte) string // 计算value版本的函数，默认是内容的hash
+
+	ttl time.Duration // 从Getter加载的value的有效期，0表示不过期
+
+	bus InvalidationBus // 失效事件总线，为nil表示不发布也不接收失效事件
+}
+
+// contentVersion 默认的版本计算方式，value内容的FNV-1a hash
+func contentVersion(key string, value []byte) string {
+	h := fnv.New64a()
+	h.Write(value)
+	return strconv.FormatUint(h.Sum64(), 16)
 }
 
 // groups是一个全局变量，那么在HTTP请求中可以获取到这个groups变量
@@ -45,11 +80,14 @@ var (
 	groups = make(map[string]*Group) // 创建一个map，用于存放group实例与命名空间的对应关系
 )
 
-// NewGroup Group构造函数
+// NewGroup Group构造函数，name不能是ReservedGroupName中的名称
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
+	if ReservedGroupName(name) {
+		panic("group name " + name + " is reserved")
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	// 初始化group
@@ -64,6 +102,15 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	return g
 }
 
+// ReservedGroupName 判断name是否和HTTPPool在basePath之下的内部路由冲突，这些名称不能作为group名称
+func ReservedGroupName(name string) bool {
+	switch name {
+	case strings.TrimSuffix(membershipPath, "/"), tagsPath, eventsPath:
+		return true
+	}
+	return false
+}
+
 // GetGroup 基于名称获取cache的实例
 func GetGroup(name string) *Group {
 	mu.RLock()
@@ -72,20 +119,82 @@ func GetGroup(name string) *Group {
 	return g
 }
 
-// populateCache 缓存查询到的数据
-func (g *Group) populateCache(key string, value *ByteView) {
+// GroupNames 获取所有group的名称，按字典序排列
+func GroupNames() []string {
+	mu.RLock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Name 获取group的名称
+func (g *Group) Name() string {
+	return g.name
+}
+
+// populateCache 缓存查询到的数据，value超过单条记录大小上限的时候不写入cache，返回ErrTooLarge
+func (g *Group) populateCache(key string, value *ByteView) error {
+	// 没有版本的value使用内容的hash作为版本，其他节点可以据此发起条件请求
+	if value.v == "" {
+		value.v = g.version(key, value.data())
+	}
+	// 开启了压缩存储，压缩之后再计算大小
+	if g.compressBytes > 0 && value.Len() >= g.compressBytes {
+		if compressed := value.compressed(); compressed.z {
+			g.Stats.UncompressedBytes.Add(int64(value.Len()))
+			g.Stats.CompressedBytes.Add(int64(compressed.Len()))
+			value = compressed
+		}
+	}
+	// 超过单条记录大小上限的value直接返回给调用方，不写入cache，避免把其他记录全部淘汰
+	if g.tooLarge(key, value) {
+		g.Stats.RejectedEntries.Add(1)
+		log.Printf("[YCache] %s/%s too large to cache: %d bytes", g.name, key, value.Len())
+		return ErrTooLarge
+	}
 	g.mainCache.Add(key, value)
+	return nil
+}
+
+// version 计算value的版本
+func (g *Group) version(key string, value []byte) string {
+	if g.versionFn != nil {
+		return g.versionFn(key, value)
+	}
+	return contentVersion(key, value)
+}
+
+// tooLarge 判断记录是否超过单条记录大小上限
+func (g *Group) tooLarge(key string, value *ByteView) bool {
+	limit := g.maxEntryBytes
+	if limit == 0 {
+		limit = g.mainCache.cacheBytes
+	}
+	if limit == 0 {
+		return false
+	}
+	return int64(len(key))+value.Cost()+g.mainCache.entryOverhead() > limit
 }
 
 // getLocally 从本地获取数据
 func (g *Group) getLocally(key string) (*ByteView, error) {
-	bytes, err := g.getter.Get(key) // 执行用户传递的回调函数
+	// 封装数据为ByteView类型
+	value, err := g.loadValue(key)
 	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
 		return &ByteView{}, err
 
 	}
-	// 封装数据为ByteView类型
-	value := &ByteView{b: cloneBytes(bytes)}
+	g.Stats.LocalLoads.Add(1)
+	// Loader要求不缓存的value直接返回给调用方
+	if value.noCache() {
+		return value, nil
+	}
+	// 超过大小上限的value没有写入cache，仍然返回给调用方
 	g.populateCache(key, value)
 	return value, nil
 }
@@ -97,6 +206,11 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (*ByteView, error) {
 		Group: g.name,
 		Key:   key,
 	}
+	// hotCache中有过期的副本，带上版本发起条件请求，value没有变化的时候不需要重新传输
+	stale, hasStale := g.hotCache.peek(key)
+	if hasStale {
+		req.Version = stale.v
+	}
 	// 构建响应对象
 	res := &ycachepb.Response{}
 	// 请求其他节点的缓存数据
@@ -104,7 +218,20 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (*ByteView, error) {
 	if err != nil {
 		return &ByteView{}, err
 	}
-	return &ByteView{b: res.Value}, nil
+	var value *ByteView
+	if res.NotModified && hasStale {
+		g.Stats.PeerNotModified.Add(1)
+		copied := *stale
+		value = &copied
+		value.e = time.Time{}
+		if res.Expire != 0 {
+			value.e = time.Unix(0, res.Expire)
+		}
+	} else {
+		value = viewFromResponse(res)
+	}
+	g.populateHotCache(key, value)
+	return value.decompressed()
 
 
 	//// 调用httpGetter的Get方法获取缓存记录
@@ -121,16 +248,26 @@ func (g *Group) load(key string) (value *ByteView, err error) {
 	// g.peers != nil 表示需要从其他节点请求数据
 
 	// 使用singleflight的Do方法包裹这段请求逻辑
-	view, err := g.loader.Do(key, func() (interface{}, error) {
+	view, err, _ := g.loader.Do(key, func() (interface{}, error) {
+		g.Stats.Loads.Add(1)
 		if g.peers != nil {
 			// 基于key获取HTTP请求信息，这个peer就是httpGetter
 			if peer, ok := g.peers.PickPeer(key); ok {
 				// 将httpGetter实例作为参数传递到getFromPeer方法中，在getFromPeer获取其他节点的缓存记录
 				if value, err = g.getFromPeer(peer, key); err == nil {
+					g.Stats.PeerLoads.Add(1)
 					return value, nil
 				}
-				// 如果请求失败了，打印日志，会执行从本地获取数据的操作
+				// 所属节点确认key不存在，不再请求备用节点，也不从本地加载
+				if errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+				g.Stats.PeerErrors.Add(1)
+				// 如果请求失败了，打印日志，交给备用节点加载，备用节点也失败了才从本地获取数据
 				log.Println("[YCache] Failed to get from peer", err)
+				if value, ok, err := g.loadFromFallback(key); ok {
+					return value, err
+				}
 			}
 		}
 		// 从本地获取数据
@@ -145,24 +282,259 @@ func (g *Group) load(key string) (value *ByteView, err error) {
 }
 
 
+// loadFromFallback 所属节点不可用时，请求备用节点代为加载，ok为false表示需要从本地加载
+// 备用节点确认key不存在的时候ok为true，err是ErrNotFound
+func (g *Group) loadFromFallback(key string) (value *ByteView, ok bool, err error) {
+	picker, isPicker := g.peers.(FallbackPicker)
+	if !isPicker {
+		return nil, false, nil
+	}
+	peer, isSelf, ok := picker.PickFallback(key)
+	// 当前节点就是备用节点，直接从本地加载，其他节点的请求会在loader中等待这次加载的结果
+	if !ok || isSelf {
+		return nil, false, nil
+	}
+	res := &ycachepb.Response{}
+	if err = peer.Get(&ycachepb.Request{Group: g.name, Key: key, Fallback: true}, res); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, true, err
+		}
+		log.Println("[YCache] Failed to get from fallback peer", err)
+		return nil, false, nil
+	}
+	g.Stats.PeerLoads.Add(1)
+	return viewFromResponse(res), true, nil
+}
+
+// populateHotCache 将其他节点的value保存到hotCache，有效期不超过hotTTL和其他节点的过期时间
+func (g *Group) populateHotCache(key string, value *ByteView) {
+	if g.hotTTL <= 0 || value.noCache() {
+		return
+	}
+	hot := *value
+	if e := time.Now().Add(g.hotTTL); hot.e.IsZero() || e.Before(hot.e) {
+		hot.e = e
+	}
+	g.hotCache.Add(key, &hot)
+}
+
+// viewFromResponse 将其他节点的响应封装为ByteView，沿用其他节点的过期时间和版本
+func viewFromResponse(res *ycachepb.Response) *ByteView {
+	view := &ByteView{b: res.Value, v: res.Version}
+	if res.Expire != 0 {
+		view.e = time.Unix(0, res.Expire)
+	}
+	if res.NoCache || len(res.Tags) > 0 || res.Priority != 0 || res.Cost != 0 || res.ContentType != "" || res.Flags != 0 {
+		// 其他节点传来的优先级不可信，限制在lru的上限之内
+		priority := int(res.Priority)
+		if priority > lru.MaxPriority {
+			priority = lru.MaxPriority
+		} else if priority < 0 {
+			priority = 0
+		}
+		view.o = &LoadOptions{NoCache: res.NoCache, Tags: res.Tags, Priority: priority, Cost: res.Cost, ContentType: res.ContentType, Flags: res.Flags}
+	}
+	return view
+}
+
+// getStale 从cache中获取value，已经过期但还没有被淘汰的value也会返回
+func (g *Group) getStale(key string) (*ByteView, bool) {
+	v, ok := g.mainCache.peek(key)
+	if !ok {
+		return nil, false
+	}
+	if v, ok = g.fromCache(&g.mainCache, key, v); !ok {
+		return nil, false
+	}
+	g.Stats.Gets.Add(1)
+	g.Stats.CacheHits.Add(1)
+	return v, true
+}
+
+// fromCache 解压cache中的value，解压失败说明记录已经损坏，从c中删除并返回false，调用方重新加载
+func (g *Group) fromCache(c *cacheInstance, key string, v *ByteView) (*ByteView, bool) {
+	d, err := v.decompressed()
+	if err != nil {
+		g.Stats.CorruptEntries.Add(1)
+		log.Printf("[YCache] %s/%s decompress failed, reloading: %v", g.name, key, err)
+		c.remove(key)
+		return nil, false
+	}
+	return d, true
+}
+
+// MinuteQPS 最近一分钟的QPS
+func (g *Group) MinuteQPS() float64 {
+	return g.qps.get(g.Stats.Gets.Get())
+}
+
+// getFallback 作为备用节点处理其他节点的请求，不再请求所属节点，同一个key在loader中只会加载一次
+func (g *Group) getFallback(key string) (*ByteView, error) {
+	if key == "" {
+		return &ByteView{}, fmt.Errorf("key is required")
+	}
+	g.Stats.Gets.Add(1)
+	if v, ok := g.mainCache.GetValue(key); ok {
+		if v, ok = g.fromCache(&g.mainCache, key, v); ok {
+			g.Stats.CacheHits.Add(1)
+			return v, nil
+		}
+	}
+	view, err, _ := g.loader.Do(key, func() (interface{}, error) {
+		g.Stats.Loads.Add(1)
+		return g.getLocally(key)
+	})
+	if err != nil {
+		return &ByteView{}, err
+	}
+	return view.(*ByteView), nil
+}
 
 // Get Group的get方法
 func (g *Group) Get(key string) (*ByteView, error) {
 	if key == "" {
 		return &ByteView{}, fmt.Errorf("key is required")
 	}
-	// 如果缓存存在，直接返回
+	g.Stats.Gets.Add(1)
+	// 如果缓存存在，直接返回，压缩存储的value解压之后返回
+	// 解压失败的记录已经被删除，和cache miss一样重新加载
 	if v, ok := g.mainCache.GetValue(key); ok {
-		log.Println("[YCache] hit")
-		return v, nil
+		if v, ok = g.fromCache(&g.mainCache, key, v); ok {
+			g.Stats.CacheHits.Add(1)
+			log.Println("[YCache] hit")
+			return v, nil
+		}
+	}
+	// 其他节点的value在本地的副本
+	if v, ok := g.hotCache.peek(key); ok && !v.expired(time.Now()) {
+		if v, ok = g.fromCache(&g.hotCache, key, v); ok {
+			g.Stats.HotHits.Add(1)
+			return v, nil
+		}
 	}
 	// 如果缓存不存在，调用load方法
 	return g.load(key)
 }
 
+// GetTo 获取key对应的value并交给dest，由dest决定拷贝方式，例如复用调用方的缓冲区或者直接解码为protobuf消息
+// Get返回的ByteView和cache共享数据，只读取的时候使用ByteView的Reader、WriteTo等方法同样不会拷贝
+func (g *Group) GetTo(key string, dest Sink) error {
+	view, err := g.Get(key)
+	if err != nil {
+		return err
+	}
+	return setSinkView(dest, view)
+}
+
+// Set 直接写入当前节点的cache，ttl为0表示不过期，value超过单条记录大小上限的时候返回ErrTooLarge，不会发布失效事件
+// 写入的记录不会同步到其他节点，key所属的节点和其他节点的hotCache中仍然可能是旧的value
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	return g.SetWithOptions(key, value, LoadOptions{TTL: ttl})
+}
+
+// SetWithOptions 和Set一样写入当前节点的cache，同时保存和Loader返回的一样的缓存选项，opts.TTL为0表示不过期
+// 写入的value总是保存在cache中，NoCache会被忽略
+func (g *Group) SetWithOptions(key string, value []byte, opts LoadOptions) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	view := &ByteView{b: cloneBytes(value)}
+	if opts.TTL > 0 {
+		view.e = time.Now().Add(opts.TTL)
+	}
+	if len(opts.Tags) > 0 || opts.Priority != 0 || opts.Cost != 0 || opts.ContentType != "" || opts.Flags != 0 {
+		opts.TTL, opts.NoCache = 0, false
+		view.o = &opts
+	}
+	g.hotCache.remove(key)
+	if err := g.populateCache(key, view); err != nil {
+		return err
+	}
+	// 本地已经写入成功，通知失败只记录日志，其他节点会在发现缺口的时候补齐
+	if err := g.publish(InvalidationEvent{Group: g.name, Key: key}); err != nil {
+		log.Println("[YCache] publish invalidation failed:", err)
+	}
+	return nil
+}
+
+// Remove 从当前节点的cache中删除记录，返回记录是否存在，设置了失效事件总线的时候通知其他节点同样删除
+func (g *Group) Remove(key string) bool {
+	ok := g.removeLocally(key)
+	if err := g.publish(InvalidationEvent{Group: g.name, Key: key}); err != nil {
+		log.Println("[YCache] publish invalidation failed:", err)
+	}
+	return ok
+}
+
+// removeLocally 从当前节点的mainCache和hotCache中删除记录
+func (g *Group) removeLocally(key string) bool {
+	hot := g.hotCache.remove(key)
+	return g.mainCache.remove(key) || hot
+}
 
 // 新增方法
 
+// SetOverheadAccounting 开启后，每条记录除了key和value的长度，还会计入链表节点、map槽位和ByteView的估算开销
+// 这样cacheBytes更接近真实占用的内存，小value较多的时候差别尤其明显
+func (g *Group) SetOverheadAccounting(on bool) {
+	var overhead int64
+	if on {
+		overhead = lru.EntryOverhead + byteViewOverhead
+	}
+	g.mainCache.setOverhead(overhead)
+}
+
+// SetMaxEntryBytes 设置单条记录的最大内存，超过的value会返回给调用方但不会被缓存，0表示以cacheBytes为上限
+func (g *Group) SetMaxEntryBytes(n int64) {
+	g.maxEntryBytes = n
+}
+
+// SetDiskCache 开启二级磁盘缓存，从内存中淘汰的记录写入path指定的日志文件，cache miss的时候先查磁盘再查其他节点和Getter
+// maxBytes是磁盘缓存的大小上限，0表示不限制
+func (g *Group) SetDiskCache(path string, maxBytes int64) error {
+	disk, err := diskcache.Open(path, maxBytes)
+	if err != nil {
+		return err
+	}
+	if old := g.mainCache.setDisk(disk); old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// CloseDiskCache 关闭二级磁盘缓存，之后从内存中淘汰的记录直接丢弃，进程退出前调用，保证后台压缩完成
+func (g *Group) CloseDiskCache() error {
+	if disk := g.mainCache.setDisk(nil); disk != nil {
+		return disk.Close()
+	}
+	return nil
+}
+
+// SetCompressValues 长度达到minBytes的value使用gzip压缩之后存入cache，读取的时候透明解压，0表示不压缩
+// 适合JSON这类压缩率高的大value，用CPU换内存
+func (g *Group) SetCompressValues(minBytes int) {
+	g.compressBytes = minBytes
+}
+
+// SetTTL 设置从Getter加载的value的有效期，过期之后重新加载，0表示不过期
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.ttl = ttl
+}
+
+// SetHotCache 开启hotCache，从其他节点获取的value在本地保存ttl时间，最多占用cacheBytes内存
+// 副本过期之后会带上版本向所属节点发起条件请求，value没有变化的时候只刷新过期时间
+func (g *Group) SetHotCache(cacheBytes int64, ttl time.Duration) {
+	g.hotCache.mu.Lock()
+	g.hotCache.cacheBytes = cacheBytes
+	g.hotCache.mu.Unlock()
+	g.hotTTL = ttl
+}
+
+// SetVersionFunc 自定义value版本的计算方式，例如使用数据源中的版本号，默认是内容的hash
+func (g *Group) SetVersionFunc(fn func(key string, value []byte) string) {
+	g.versionFn = fn
+}
+
 // RegisterPeers 将HTTPPool绑定到Group中
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
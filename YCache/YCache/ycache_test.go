@@ -7,9 +7,11 @@
 package YCache
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"seven-days-projects/YCache/YCache/ycachepb"
 	"testing"
 )
 
@@ -69,4 +71,136 @@ func TestGet(t *testing.T) {
 		}
 
 	}
-}
\ No newline at end of file
+}
+
+// TestMaxEntryBytes 超过单条记录大小上限的value返回给调用方，但不写入cache
+func TestMaxEntryBytes(t *testing.T) {
+	loads := 0
+	g := NewGroup("max-entry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if key == "big" {
+			return make([]byte, 100), nil
+		}
+		return []byte("small"), nil
+	}))
+	g.SetMaxEntryBytes(64)
+	g.Get("small")
+
+	for i := 0; i < 2; i++ {
+		if view, err := g.Get("big"); err != nil || view.Len() != 100 {
+			t.Fatalf("failed to get big value")
+		}
+	}
+	if loads != 3 || g.Stats.RejectedEntries.Get() != 2 {
+		t.Fatalf("big value should not be cached, loads %d, rejected %d", loads, g.Stats.RejectedEntries.Get())
+	}
+	if _, ok := g.mainCache.GetValue("small"); !ok {
+		t.Fatalf("small value should not be evicted")
+	}
+	if err := g.Set("big", make([]byte, 100), 0); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Set should report a value too large to cache, got %v", err)
+	}
+}
+
+// fakePeer 测试用的PeerGetter
+type fakePeer struct {
+	err      error
+	requests []*ycachepb.Request
+}
+
+func (p *fakePeer) Get(in *ycachepb.Request, out *ycachepb.Response) error {
+	p.requests = append(p.requests, in)
+	if p.err != nil {
+		return p.err
+	}
+	out.Value = []byte("peer-" + in.Key)
+	return nil
+}
+
+// fakePicker 测试用的PeerPicker和FallbackPicker
+type fakePicker struct {
+	owner, fallback *fakePeer
+	fallbackIsSelf  bool
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.owner, true
+}
+
+func (p *fakePicker) PickFallback(key string) (PeerGetter, bool, bool) {
+	if p.fallbackIsSelf {
+		return nil, true, true
+	}
+	return p.fallback, false, true
+}
+
+// TestLoadFromFallback 所属节点不可用时，交给备用节点加载，而不是每个节点都请求数据源
+func TestLoadFromFallback(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("local-" + key), nil
+	})
+
+	picker := &fakePicker{owner: &fakePeer{err: fmt.Errorf("connection refused")}, fallback: &fakePeer{}}
+	g := NewGroup("fallback", 2<<10, getter)
+	g.RegisterPeers(picker)
+	view, err := g.Get("Tom")
+	if err != nil || view.String() != "peer-Tom" || loads != 0 {
+		t.Fatalf("expect value from fallback peer, got %v %v, loads %d", view, err, loads)
+	}
+	if len(picker.fallback.requests) != 1 || !picker.fallback.requests[0].Fallback {
+		t.Fatalf("fallback request should carry the fallback flag")
+	}
+
+	// 当前节点就是备用节点，从本地加载
+	self := NewGroup("fallback-self", 2<<10, getter)
+	self.RegisterPeers(&fakePicker{owner: &fakePeer{err: fmt.Errorf("connection refused")}, fallbackIsSelf: true})
+	if view, err := self.Get("Tom"); err != nil || view.String() != "local-Tom" || loads != 1 {
+		t.Fatalf("expect value from local getter, got %v %v", view, err)
+	}
+
+	// 备用节点也不可用，从本地加载
+	picker.fallback.err = fmt.Errorf("connection refused")
+	if view, err := g.Get("Jack"); err != nil || view.String() != "local-Jack" || loads != 2 {
+		t.Fatalf("expect value from local getter, got %v %v", view, err)
+	}
+}
+
+// TestPeerNotFound 所属节点确认key不存在的时候直接返回ErrNotFound，不再请求备用节点，也不从本地加载
+func TestPeerNotFound(t *testing.T) {
+	loads := 0
+	picker := &fakePicker{owner: &fakePeer{err: fmt.Errorf("Sam: %w", ErrNotFound)}, fallback: &fakePeer{}}
+	g := NewGroup("peer-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, ErrNotFound
+	}))
+	g.RegisterPeers(picker)
+	if _, err := g.Get("Sam"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if len(picker.fallback.requests) != 0 || loads != 0 || g.Stats.PeerErrors.Get() != 0 {
+		t.Fatalf("missing key should be loaded once, got %d fallback requests, %d local loads", len(picker.fallback.requests), loads)
+	}
+
+	// 所属节点不可用，备用节点确认key不存在，也不再从本地加载
+	picker.owner.err = fmt.Errorf("connection refused")
+	picker.fallback.err = fmt.Errorf("Sam: %w", ErrNotFound)
+	if _, err := g.Get("Sam"); !errors.Is(err, ErrNotFound) || loads != 0 {
+		t.Fatalf("expect ErrNotFound from fallback peer, got %v, %d local loads", err, loads)
+	}
+}
+
+// TestReservedGroupName 和HTTPPool内部路由冲突的名称不能作为group名称
+func TestReservedGroupName(t *testing.T) {
+	for _, name := range []string{"_tags", "_events", "_peers"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("NewGroup(%q) should panic", name)
+				}
+			}()
+			NewGroup(name, 2<<10, GetterFunc(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+		}()
+	}
+}
@@ -7,92 +7,420 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	YCache2 "seven-days-projects/YCache/YCache"
+	"seven-days-projects/YCache/YCache/api"
+	"seven-days-projects/YCache/YCache/config"
+	"seven-days-projects/YCache/YCache/getters"
+	"seven-days-projects/YCache/YCache/memcache"
+	"seven-days-projects/YCache/YCache/resp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var db = map[string]string{
-	"Tom":  "630",
-	"Jack": "589",
-	"Sam":  "567",
+// defaultConfig 没有指定配置文件时使用的配置：本机的三个节点和一个scores group
+func defaultConfig() *config.Config {
+	return &config.Config{
+		Self: "http://localhost:8001",
+		Peers: []string{
+			"http://localhost:8001",
+			"http://localhost:8002",
+			"http://localhost:8003",
+		},
+		Groups: []config.GroupConfig{{
+			Name:       "scores",
+			CacheBytes: 2 << 10,
+			Getter: config.GetterConfig{
+				Type:   "static",
+				Params: []byte(`{"Tom": "630", "Jack": "589", "Sam": "567"}`),
+			},
+		}},
+	}
 }
 
-// 创建实例
-func createGroup() *YCache2.Group {
-	return YCache2.NewGroup("scores", 2<<10, YCache2.GetterFunc(
-		func(key string) ([]byte, error) {
-			log.Println("[SlowDB] search key", key)
-			if v, ok := db[key]; ok {
-				return []byte(v), nil
-			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+// registerGetters 注册getters包中的数据源，配置文件中getter.type可以使用file、http和sql
+// sql类型需要在编译的时候引入对应的数据库驱动
+func registerGetters() {
+	config.RegisterGetter("file", func(params json.RawMessage) (YCache2.Getter, error) {
+		var p struct {
+			Root     string `json:"root"`
+			MaxBytes int64  `json:"max_bytes"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Root == "" {
+			return nil, errors.New("root is required")
+		}
+		return &getters.FileGetter{Root: p.Root, MaxBytes: p.MaxBytes}, nil
+	})
+	config.RegisterGetter("http", func(params json.RawMessage) (YCache2.Getter, error) {
+		var p struct {
+			URL      string          `json:"url"`
+			Timeout  config.Duration `json:"timeout"`
+			MaxBytes int64           `json:"max_bytes"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		g, err := getters.NewHTTPGetter(p.URL)
+		if err != nil {
+			return nil, err
+		}
+		g.Timeout, g.MaxBytes = p.Timeout.Value(), p.MaxBytes
+		return g, nil
+	})
+	config.RegisterGetter("sql", func(params json.RawMessage) (YCache2.Getter, error) {
+		var p struct {
+			Driver  string          `json:"driver"`
+			DSN     string          `json:"dsn"`
+			Query   string          `json:"query"`
+			Timeout config.Duration `json:"timeout"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Query == "" {
+			return nil, errors.New("query is required")
+		}
+		db, err := sql.Open(p.Driver, p.DSN)
+		if err != nil {
+			return nil, err
+		}
+		g := getters.NewSQLGetter(db, p.Query)
+		g.Timeout = p.Timeout.Value()
+		return g, nil
+	})
+}
+
+// defaultShutdownTimeout 等待处理中的请求完成的默认时长
+const defaultShutdownTimeout = 10 * time.Second
+
+// busSyncInterval 定期向其他节点拉取错过的失效事件的间隔
+const busSyncInterval = 30 * time.Second
+
+// 通知其他节点重新加入hash环失败之后重试的间隔，每次翻倍，不超过maxJoinRetryDelay
+const (
+	joinRetryDelay    = time.Second
+	maxJoinRetryDelay = 30 * time.Second
+)
+
+// shutdowner 可以等待处理中的请求完成之后再关闭的服务
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// node 当前节点上运行的所有服务，收到退出信号的时候依次关闭
+type node struct {
+	cfg       *config.Config
+	groups    []*YCache2.Group
+	pool      *YCache2.HTTPPool
+	servers   []*http.Server // 节点间通信和对外的HTTP服务，可以等待处理中的请求完成
+	frontends []shutdowner   // Redis和memcached协议的服务，等待正在执行的命令完成
+	closers   []io.Closer    // 失效事件总线等后台任务，直接关闭
+
+	stopJoin context.CancelFunc // 停止重试加入hash环
+	joined   chan struct{}      // 重试加入hash环的goroutine已经退出
+}
+
+// serve 在后台运行服务，正常关闭之外的错误直接退出进程
+func serve(listen func() error) {
+	go func() {
+		if err := listen(); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Fatal(err)
+		}
+	}()
 }
 
 // 启动cache通信的HTTP服务
-func startCacheServer(addr string, addrs []string, group *YCache2.Group) {
+func (n *node) startCacheServer() {
 	// 实例化HTTPPool
-	peers := YCache2.NewHTTPPool(addr)
+	peers, err := YCache2.NewHTTPPoolOpts(n.cfg.Self, n.cfg.PoolOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
 	// 设置cache IP与HTTP信息的对应关系
-	peers.Set(addrs...)
-	// 将HTTPPool绑定到group中
-	group.RegisterPeers(peers)
-	log.Println("YCache is running at", addr)
-	// 启动服务 阻塞
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
-}
-
-// 在启动一个对外的HTTP api服务
-func startAPIServer(apiAddr string, group *YCache2.Group) {
-	http.Handle("/api", http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			// 获取请求的key
-			key := r.URL.Query().Get("key")
-			// 查询当前节点的缓存记录，如果当前节点没有，请求其他节点，如果没有就从本地数据库加载
-			view, err := group.Get(key)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			// 这里将数据拷贝了一份，避免指针的原因，高并发的时候，数据被修改
-			w.Write(view.ByteSlice())
+	peers.Set(n.cfg.Peers...)
+	// 将HTTPPool绑定到group中，修改和删除通过失效事件通知其他节点
+	bus := YCache2.NewPeerBus(peers, &YCache2.PeerBusOptions{SyncInterval: busSyncInterval})
+	for _, group := range n.groups {
+		group.RegisterPeers(peers)
+		group.SetInvalidationBus(bus)
+	}
+	n.pool = peers
+	n.closers = append(n.closers, bus)
+	server := &http.Server{Addr: n.cfg.CacheAddr(), Handler: peers}
+	n.servers = append(n.servers, server)
+	log.Println("YCache is running at", n.cfg.Self)
+	if n.cfg.TLS.CertFile == "" {
+		serve(server.ListenAndServe)
+		return
+	}
+	if server.TLSConfig, err = peers.ServerTLSConfig(); err != nil {
+		log.Fatal(err)
+	}
+	serve(func() error { return server.ListenAndServeTLS(n.cfg.TLS.CertFile, n.cfg.TLS.KeyFile) })
+}
 
-		}))
+// 在启动一个对外的HTTP api服务，路由为/v1/groups/{group}/keys/{key}
+func (n *node) startAPIServer(apiAddr string) {
+	server := &http.Server{Addr: apiAddr, Handler: api.NewServer()}
+	n.servers = append(n.servers, server)
 	log.Println("fontend api server is running at", apiAddr)
-	// apiAddr[7:]为：localhost:8001
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	serve(server.ListenAndServe)
+}
 
+// 启动兼容Redis协议的前端服务，key的格式为group:key
+func (n *node) startRESPServer(addr string) {
+	server := resp.NewServer()
+	n.frontends = append(n.frontends, server)
+	log.Println("redis protocol server is running at", addr)
+	serve(func() error { return server.ListenAndServe(addr) })
 }
 
-func main() {
+// 启动兼容memcached协议的前端服务，只有一个group的时候key不需要加上group前缀，
+// 有多个group的时候key的格式为group:key，每个group都可以访问
+func (n *node) startMemcacheServer(addr string) {
+	var defaultGroup string
+	if len(n.groups) == 1 {
+		defaultGroup = n.groups[0].Name()
+	}
+	server := memcache.NewServer(defaultGroup)
+	n.frontends = append(n.frontends, server)
+	log.Println("memcached protocol server is running at", addr)
+	serve(func() error { return server.ListenAndServe(addr) })
+}
+
+// waitForShutdown 收到退出信号之后：通知其他节点把当前节点移出hash环，停止接受新请求并等待处理中的请求完成，
+// 最后保存快照再退出进程。等待的时间不超过ShutdownTimeout
+func (n *node) waitForShutdown() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-ch
+	log.Println("received", sig, "shutting down")
+
+	timeout := n.cfg.ShutdownTimeout.Value()
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 还在重试加入hash环的话先停止，避免离开之后又重新加入
+	n.stopJoin()
+	<-n.joined
+	// 先让其他节点不再把请求路由到当前节点，再关闭服务
+	if err := n.pool.Leave(ctx); err != nil {
+		log.Println("announce departure failed:", err)
+	}
+	var wg sync.WaitGroup
+	for _, server := range n.servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Println("shutdown", server.Addr, "failed:", err)
+			}
+		}(server)
+	}
+	for _, f := range n.frontends {
+		wg.Add(1)
+		go func(f shutdowner) {
+			defer wg.Done()
+			if err := f.Shutdown(ctx); err != nil {
+				log.Println("shutdown frontend failed:", err)
+			}
+		}(f)
+	}
+	wg.Wait()
+	for _, c := range n.closers {
+		c.Close()
+	}
+	n.pool.Stop()
+
+	code := 0
+	if n.cfg.Snapshot != "" {
+		for _, group := range n.groups {
+			if err := saveSnapshot(snapshotPath(n.cfg.Snapshot, group, len(n.groups)), group); err != nil {
+				log.Println("snapshot failed:", err)
+				code = 1
+			}
+		}
+	}
+	for _, group := range n.groups {
+		if err := group.CloseDiskCache(); err != nil {
+			log.Println("close disk cache failed:", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// join 通知其他节点把当前节点重新加入hash环，有节点没有确认的时候按退避间隔重试，直到全部确认或者ctx取消
+// 其他节点开启了主动健康检查的时候，即使一直没有收到通知，也会在探测到当前节点恢复之后重新加入
+func (n *node) join(ctx context.Context) {
+	defer close(n.joined)
+	delay := joinRetryDelay
+	for {
+		attempt, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := n.pool.Join(attempt)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("announce join failed, retrying in %v: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if delay *= 2; delay > maxJoinRetryDelay {
+			delay = maxJoinRetryDelay
+		}
+	}
+}
+
+// snapshotPath 每个group一个快照文件，只有一个group的时候直接使用path
+func snapshotPath(path string, group *YCache2.Group, groups int) string {
+	if groups == 1 {
+		return path
+	}
+	return path + "." + group.Name()
+}
+
+// 从快照文件恢复缓存记录，文件不存在的时候跳过
+func restoreSnapshot(path string, group *YCache2.Group) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println("open snapshot failed:", err)
+		return
+	}
+	defer f.Close()
+	if err = group.Restore(f); err != nil {
+		log.Println("restore snapshot failed:", err)
+		return
+	}
+	log.Println("snapshot restored from", path)
+}
+
+// saveSnapshot 先写入临时文件再重命名，避免写到一半退出导致快照文件损坏
+func saveSnapshot(path string, group *YCache2.Group) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err == nil {
+		err = group.Snapshot(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		log.Println("snapshot saved to", path)
+	}
+	return err
+}
+
+// loadConfig 加载配置，优先级从低到高依次是配置文件(或默认配置)、环境变量、命令行参数
+func loadConfig() (*config.Config, error) {
+	var path, self, peers, snapshot, respAddr, memcacheAddr string
 	var port int
-	var api bool
-	flag.IntVar(&port, "port", 8001, "YCache server port")
-	flag.BoolVar(&api, "api", false, "Start a api server?")
+	var startAPI bool
+	flag.StringVar(&path, "config", "", "Config file (JSON), the built-in three-node config is used if empty")
+	flag.IntVar(&port, "port", 8001, "YCache server port, shorthand for -self=http://localhost:<port>")
+	flag.StringVar(&self, "self", "", "Address of this node, e.g. http://localhost:8001")
+	flag.StringVar(&peers, "peers", "", "Comma separated addresses of all nodes")
+	flag.BoolVar(&startAPI, "api", false, "Start a api server?")
+	flag.StringVar(&snapshot, "snapshot", "", "Snapshot file, restored at startup and saved on shutdown")
+	flag.StringVar(&respAddr, "resp", "", "Redis protocol listen address, e.g. localhost:6380")
+	flag.StringVar(&memcacheAddr, "memcache", "", "Memcached protocol listen address, e.g. localhost:11211")
 	flag.Parse()
 
-	apiAddr := "http://localhost:9999"
-	// cache实例对应的IP地址
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+	cfg := defaultConfig()
+	if path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			return nil, err
+		}
 	}
-	// 存储所有的cache节点IP
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	cfg.ApplyEnv()
+	// 只有明确指定的命令行参数才覆盖配置
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Self = "http://localhost:" + strconv.Itoa(port)
+			cfg.Listen.Cache = ""
+		case "self":
+			cfg.Self = self
+		case "peers":
+			cfg.Peers = config.SplitList(peers)
+		case "api":
+			if startAPI && cfg.Listen.API == "" {
+				cfg.Listen.API = "localhost:9999"
+			} else if !startAPI {
+				cfg.Listen.API = ""
+			}
+		case "snapshot":
+			cfg.Snapshot = snapshot
+		case "resp":
+			cfg.Listen.RESP = respAddr
+		case "memcache":
+			cfg.Listen.Memcache = memcacheAddr
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+func main() {
+	registerGetters()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 	// 创建本地cache实例
-	group := createGroup()
-	// 启动api服务器
-	if api {
-		go startAPIServer(apiAddr, group)
+	groups, err := cfg.NewGroups()
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := &node{cfg: cfg, groups: groups}
+	// 指定了快照文件，启动的时候恢复，退出的时候保存
+	if cfg.Snapshot != "" {
+		for _, group := range groups {
+			restoreSnapshot(snapshotPath(cfg.Snapshot, group, len(groups)), group)
+		}
 	}
 	// 启动cache通信服务器
-	startCacheServer(addrMap[port], []string(addrs), group)
-}
\ No newline at end of file
+	n.startCacheServer()
+	// 启动api服务器
+	if cfg.Listen.API != "" {
+		n.startAPIServer(cfg.Listen.API)
+	}
+	// 启动Redis协议服务器
+	if cfg.Listen.RESP != "" {
+		n.startRESPServer(cfg.Listen.RESP)
+	}
+	// 启动memcached协议服务器
+	if cfg.Listen.Memcache != "" {
+		n.startMemcacheServer(cfg.Listen.Memcache)
+	}
+	// 节点重启之后通知其他节点重新把当前节点加入hash环，其他节点暂时不可用的时候在后台重试
+	ctx, stop := context.WithCancel(context.Background())
+	n.stopJoin, n.joined = stop, make(chan struct{})
+	go n.join(ctx)
+	n.waitForShutdown()
+}
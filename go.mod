@@ -1,8 +1,8 @@
 module seven-days-projects
 
-go 1.16
+go 1.18
 
 require (
-	github.com/golang/protobuf v1.5.2 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
+	github.com/golang/protobuf v1.5.2
+	google.golang.org/protobuf v1.27.1
 )